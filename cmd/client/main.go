@@ -2,26 +2,92 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log/slog"
+	"os"
 
 	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/app/client"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
 )
 
-func parseArgs() (remote string) {
+func parseArgs() (remote string, options client.Options) {
 	dFlag := flag.Bool("d", false, "enable debug log level")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
+	logFile := flag.String("log-file", "", "write logs to this file instead of stderr")
+	logLevels := flag.String("log-levels", "", "per-subsystem log levels, e.g. protocol=debug")
 	aFlag := flag.String("a", "127.0.0.1", "server address (default: 127.0.0.1)")
 	pFlag := flag.String("p", "3333", "server port (default: 3333)")
+	parallelFlag := flag.Int("parallel", 1, "number of connections used to download a large file")
+	shareFlag := flag.String("share", "default", "share used by the get and sync modes")
+	workersFlag := flag.Int("workers", 4, "number of connections used by the get and sync modes")
+	retriesFlag := flag.Int("retries", 2, "retries of a failed file in the get and sync modes")
+	deleteFlag := flag.Bool("delete", false, "sync mode: delete local files no longer on the server")
+	checksumFlag := flag.Bool("checksum", false, "sync mode: compare the hash of files with the same size, even if their date matches")
+	dryRunFlag := flag.Bool("dry-run", false, "sync mode: show the planned actions without changing anything")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [get <file or pattern>... | sync <directory>]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	level := slog.LevelInfo
 	if *dFlag {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
+		level = slog.LevelDebug
+	}
+	err := logging.Setup(logging.Options{Format: *logFormat, File: *logFile, Level: level, Subsystems: *logLevels})
+	if err != nil {
+		slog.Error("Invalid logging options", "error", err)
+		os.Exit(1)
+	}
+
+	if *parallelFlag < 1 {
+		slog.Error("Invalid -parallel, must be at least 1", "parallel", *parallelFlag)
+		os.Exit(1)
+	}
+	if *workersFlag < 1 || *retriesFlag < 0 {
+		slog.Error("Invalid -workers or -retries", "workers", *workersFlag, "retries", *retriesFlag)
+		os.Exit(1)
 	}
 
 	remote = *aFlag + ":" + *pFlag
+	options.Parallel = *parallelFlag
+	options.Share = *shareFlag
+	options.Workers = *workersFlag
+	options.Retries = *retriesFlag
+	options.Delete = *deleteFlag
+	options.Checksum = *checksumFlag
+	options.DryRun = *dryRunFlag
 	return
 }
 
 func main() {
-	remote := parseArgs()
-	client.Run(remote)
+	remote, options := parseArgs()
+
+	// Sans mode, le client est interactif
+	args := flag.Args()
+	if len(args) == 0 {
+		client.Run(remote, options)
+		return
+	}
+	switch args[0] {
+	case "get":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if !client.Download(remote, options, args[1:]) {
+			os.Exit(1)
+		}
+	case "sync":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if !client.Sync(remote, options, args[1]) {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 }
@@ -2,38 +2,107 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/app/server"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
 )
 
-func parseArgs() (port *string, controlPort *string, dir *string) {
+// lireConfig construit la configuration a partir des arguments : valeurs par
+// defaut, puis fichier de configuration (-config), puis options donnees sur
+// la ligne de commande. La configuration retournee est validee.
+func lireConfig(args []string) (config server.Config, checkConfig bool, err error) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
-	logLevel := flag.Bool("d", false, "enable debug log level")
-	port = flag.String("p", "3333", "server port (default: 3333)")
-	controlPort = flag.String("c", "3334", "control port (default: 3334)")	
+	configFile := flags.String("config", "", "JSON configuration file (command line flags override its values)")
+	check := flags.Bool("check-config", false, "validate the configuration and exit")
+	logLevel := flags.Bool("d", false, "enable debug log level")
+	flags.StringVar(&config.Log.Format, "log-format", "text", "log output format: text or json")
+	flags.StringVar(&config.Log.File, "log-file", "", "write logs to this file instead of stderr")
+	flags.StringVar(&config.Log.Subsystems, "log-levels", "", "per-subsystem log levels, e.g. protocol=debug,control=warn (subsystems: session, control, protocol)")
+	flags.StringVar(&config.Port, "p", "3333", "server port (default: 3333)")
+	flags.StringVar(&config.ControlPort, "c", "3334", "control port (default: 3334)")	
 	// Parametre pour le dossier
-	dir = flag.String("dir", ".", "directory to serve (default: .)")
+	flags.StringVar(&config.Dir, "dir", ".", "directory to serve (default: .)")
+	// Partages supplementaires, ajoutes a ceux du fichier de configuration
+	var partages []server.Share
+	flags.Func("share", "additional named share: name=path[,rw] (repeatable)", func(def string) error {
+		share, err := server.ParseShare(def)
+		if err != nil {
+			return err
+		}
+		partages = append(partages, share)
+		return nil
+	})
+	flags.BoolVar(&config.Chunked, "chunked", false, "always use chunked transfer for Get")
+	flags.BoolVar(&config.Compress, "compress", true, "compress transfers when the client supports it")
+	flags.Int64Var(&config.CompressMinSize, "compress-min", 1024, "minimum file size in bytes for compression")
+	flags.Int64Var(&config.GlobalRate, "rate", 0, "global bandwidth limit in bytes/s (0: unlimited)")
+	flags.Int64Var(&config.ClientRate, "client-rate", 0, "per-connection bandwidth limit in bytes/s (0: unlimited)")
+	flags.IntVar(&config.MaxClients, "max-clients", 0, "maximum number of clients served at once (0: unlimited)")
+	flags.IntVar(&config.QueueSize, "queue", 0, "number of clients allowed to wait for a slot (0: reject when full)")
+	flags.Int64Var(&config.UserQuota, "user-quota", 0, "space in bytes that the files uploaded by one client address may use (0: unlimited)")
+	flags.Int64Var(&config.MaxFileSize, "max-file-size", 0, "maximum size in bytes of an uploaded file (0: unlimited)")
+	flags.DurationVar(&config.IdleTimeout, "idle-timeout", 0, "disconnect clients idle for this duration (0: never)")
+	flags.DurationVar(&config.ListingPoll, "listing-poll", 2*time.Second, "cache directory listings and check them for changes at this interval (0: no cache)")
+	flags.BoolVar(&config.ListingCheckFiles, "listing-check-files", false, "also stat every cached file at each check, to see files modified in place")
+	flags.StringVar(&config.AclFile, "acl", "", "access rules file (allow/deny networks, per-address limits)")
+	flags.StringVar(&config.AccessLog, "access-log", "", "access log file, one JSON line per session (default: disabled)")
+	flags.Int64Var(&config.AccessLogMaxSize, "access-log-max-size", 10<<20, "rotate the access log beyond this size in bytes (0: never)")
+	flags.DurationVar(&config.AccessLogMaxAge, "access-log-max-age", 24*time.Hour, "rotate the access log after this duration (0: never)")
+	flags.StringVar(&config.MetricsAddr, "metrics", "", "HTTP address for Prometheus metrics, e.g. 127.0.0.1:9100 (default: disabled)")
+	flags.BoolVar(&config.Dashboard, "dashboard", false, "show a live status view on stdout (redirect logs with 2>file)")
 
-	flag.Parse()
+	flags.Parse(args)
 
+	if *configFile != "" {
+		if err = server.LireConfig(*configFile, &config); err != nil {
+			return
+		}
+		// Relit la ligne de commande pour que les options l'emportent sur le fichier
+		partages = nil
+		flags.Parse(args)
+	}
+	config.Shares = append(config.Shares, partages...)
 	if *logLevel {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-		slog.Debug("Set logging level to debug")
+		config.Log.Level = slog.LevelDebug
+	}
+	checkConfig = *check
+	err = config.Valider()
+	return
+}
+
+func parseArgs() (config server.Config) {
+	config, checkConfig, err := lireConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if checkConfig {
+		fmt.Println("Configuration OK")
+		os.Exit(0)
 	}
 
-	// Verfie si le dossier existe
-	if info, err := os.Stat(*dir); err != nil || !info.IsDir() {
-		slog.Error("The specified directory does not exist or is not a directory: " + *dir)
+	if err := logging.Setup(config.Log); err != nil {
+		slog.Error("Invalid logging options", "error", err)
 		os.Exit(1)
 	}
+	slog.Debug("Set logging level to debug")
 
+	// Rechargement (SIGHUP ou commande Reload) : memes arguments, fichier relu
+	config.Charger = func() (server.Config, error) {
+		c, _, err := lireConfig(os.Args[1:])
+		return c, err
+	}
 	return
 }
 
 func main() {
-	port, controlPort, dir := parseArgs()
+	config := parseArgs()
 	// Ajout du dossier au serveur
-	server.RunServer(port, controlPort, dir)
+	server.RunServer(config)
 }
\ No newline at end of file
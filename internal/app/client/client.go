@@ -2,17 +2,44 @@ package client
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/sendrec"
 )
 
-func Run(remote string) {
+// Encodages de compression annonces au serveur pour chaque Get
+var encodagesAcceptes = proto.EncodingGzip + "," + proto.EncodingDeflate
+
+// Partage selectionne par le serveur a la connexion
+const partageParDefaut = "default"
+
+// Options du client
+type Options struct {
+	// Nombre de connexions pour telecharger un gros fichier (1 = une seule)
+	Parallel int
+	// Modes get et sync : partage, nombre de connexions et tentatives par fichier
+	Share   string
+	Workers int
+	Retries int
+	// Mode sync : suppression des fichiers locaux absents du serveur,
+	// comparaison systematique des empreintes, simulation sans modification
+	Delete   bool
+	Checksum bool
+	DryRun   bool
+}
+
+func Run(remote string, options Options) {
 	c, e := net.Dial("tcp", remote)
 	if e != nil {
 		slog.Error(e.Error())
@@ -25,23 +52,53 @@ func Run(remote string) {
 	slog.Info("Connected to " + c.RemoteAddr().String())
 
 	// Lire ce que l'utilisateur tape dans la console
-	consoleScanner := bufio.NewScanner(os.Stdin)
+	console := lireConsole(os.Stdin)
 	// Lire ce que le serveur envoie
 	serverReader := bufio.NewReader(c)
+	// Partage courant, repris par les connexions des telechargements paralleles
+	partage := partageParDefaut
 
-	for {		
-		// Lire la commande
-		if !consoleScanner.Scan() {
-			break
-		}
-		
-		input := consoleScanner.Text()
+	for input := range console {
 		parts := strings.Fields(input)
 		if len(parts) == 0 {
 			continue
 		}
 		cmd := parts[0]
 
+		// Watch envoie lui-meme sa commande, puis lit la console pour s'arreter
+		if cmd == proto.CommandeWatch {
+			pattern := ""
+			if len(parts) >= 2 {
+				pattern = parts[1]
+			}
+			gererWatch(c, serverReader, console, pattern)
+			continue
+		}
+		// Put annonce la taille du fichier local
+		if cmd == proto.CommandePut {
+			if len(parts) < 2 {
+				fmt.Println("Usage: Put <local file> [<remote name>]")
+				continue
+			}
+			remoteName := filepath.Base(parts[1])
+			if len(parts) >= 3 {
+				remoteName = parts[2]
+			}
+			gererPut(c, serverReader, parts[1], remoteName)
+			continue
+		}
+
+		// Get en parallele : demande d'abord la taille et l'empreinte du fichier
+		if cmd == proto.CommandeGet && len(parts) >= 2 && options.Parallel > 1 {
+			c, serverReader = gererGetParallele(c, serverReader, remote, partage, parts[1], options.Parallel)
+			continue
+		}
+
+		// Pour Get, annonce les encodages de compression supportes
+		if cmd == proto.CommandeGet && len(parts) >= 2 {
+			input = fmt.Sprintf("%s %s %s", cmd, parts[1], encodagesAcceptes)
+		}
+
 		// Envoie de la commande au serveur
 		_, err := fmt.Fprintf(c, "%s\n", input)
 		if err != nil {
@@ -60,6 +117,22 @@ func Run(remote string) {
 			}
 			filename := parts[1]
 			gererGetReponse(c, serverReader, filename)
+		case proto.CommandeUse:
+			if gererUseReponse(serverReader) && len(parts) >= 2 {
+				partage = parts[1]
+			}
+		case proto.CommandeShares:
+			gererSharesReponse(serverReader)
+		case proto.CommandeHash:
+			gererHashReponse(serverReader)
+		case proto.CommandeDelete, proto.CommandeRename, proto.CommandeMkdir:
+			gererModificationReponse(serverReader, cmd)
+		case proto.CommandeFollow:
+			if len(parts) < 2 {
+				fmt.Println("Usage: Follow <filename> [<lines>]")
+				continue
+			}
+			gererFollowReponse(c, serverReader, console, parts[1])
 		case proto.CommandeEnd:
 			return
 		default:
@@ -68,14 +141,60 @@ func Run(remote string) {
 	}
 }
 
+// lireConsole retourne les lignes tapees par l'utilisateur ; le canal est
+// ferme a la fin de l'entree.
+func lireConsole(in io.Reader) <-chan string {
+	lignes := make(chan string)
+	go func() {
+		defer close(lignes)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lignes <- scanner.Text()
+		}
+	}()
+	return lignes
+}
+
+// errServerBusy signale que le serveur a refuse la connexion (trop de clients).
+var errServerBusy = errors.New("server busy, connection refused")
+
+// lireReponse lit la prochaine ligne de reponse du serveur, en affichant
+// les messages de file d'attente ("Queued <n>", "Ready") qui peuvent la preceder.
+func lireReponse(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSpace(line)
+		parts := strings.Fields(line)
+
+		switch {
+		case line == proto.ReponseBusy:
+			return "", errServerBusy
+		case line == proto.ReponseReady:
+			fmt.Println("A slot is available, request is being served")
+		case len(parts) == 2 && parts[0] == proto.ReponseQueued:
+			fmt.Printf("Server full, waiting in queue (position %s)...\n", parts[1])
+		default:
+			return line, nil
+		}
+	}
+}
+
 func gererListReponse(c net.Conn, reader *bufio.Reader) {
 	// Lire "FileCnt N"
-	line, err := reader.ReadString('\n')
+	line, err := lireReponse(reader)
 	if err != nil {
 		slog.Error("Error reading FileCnt", "error", err)
 		return
 	}
-	line = strings.TrimSpace(line)
+
+	// "Error <message>" : commande refusee (par exemple, aucun partage choisi)
+	if strings.HasPrefix(line, proto.ReponseError+" ") {
+		fmt.Println(line)
+		return
+	}
 
 	// Analyse la chaine "FileCnt N"
 	parts := strings.Fields(line)
@@ -107,74 +226,369 @@ func gererListReponse(c net.Conn, reader *bufio.Reader) {
 	slog.Debug("Sent OK confirmation")
 }
 
+// gererUseReponse retourne true si le partage a ete selectionne.
+func gererUseReponse(reader *bufio.Reader) bool {
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading Use response", "error", err)
+		return false
+	}
+	if line == proto.ReponseOk {
+		fmt.Println("Share selected")
+		return true
+	}
+	fmt.Println(line)
+	return false
+}
+
+// gererHashReponse affiche la taille et l'empreinte SHA-256 du fichier.
+func gererHashReponse(reader *bufio.Reader) {
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading server response", "command", proto.CommandeHash, "error", err)
+		return
+	}
+	parts := strings.Fields(line)
+	switch {
+	case line == proto.ReponseFileUnknown:
+		fmt.Println("Error: File not found on server")
+	case len(parts) == 3 && parts[0] == proto.ReponseHash:
+		fmt.Printf("Size: %s bytes, SHA-256: %s\n", parts[1], parts[2])
+	default:
+		fmt.Println(line)
+	}
+}
+
+func gererModificationReponse(reader *bufio.Reader, cmd string) {
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading server response", "command", cmd, "error", err)
+		return
+	}
+	switch line {
+	case proto.ReponseOk:
+		fmt.Printf("%s done\n", cmd)
+	case proto.ReponseFileUnknown:
+		fmt.Println("Error: File not found on server")
+	default:
+		fmt.Println(line)
+	}
+}
+
+func gererSharesReponse(reader *bufio.Reader) {
+	// Lire "ShareCnt N"
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading ShareCnt", "error", err)
+		return
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 2 || parts[0] != proto.ReponseShareCount {
+		slog.Error("Invalid protocol format", "received", line)
+		return
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		slog.Error("Invalid share count", "received", parts[1])
+		return
+	}
+
+	fmt.Printf("ShareCnt %d\n", count)
+	for i := 0; i < count; i++ {
+		shareLine, err := reader.ReadString('\n')
+		if err != nil {
+			slog.Error("Error reading share info", "error", err)
+			return
+		}
+		fmt.Print(" - " + shareLine)
+	}
+}
+
 func gererGetReponse(c net.Conn, reader *bufio.Reader, filename string) {
 	// Lire la premiere ligne de reponse du serveur
-	line, err := reader.ReadString('\n')
+	line, err := lireReponse(reader)
 	if err != nil {
 		slog.Error("Error reading server response", "error", err)
 		return
 	}
-	line = strings.TrimSpace(line)
 
 	// Verifie si c'est FileUnknown
 	if line == proto.ReponseFileUnknown {
 		fmt.Printf("Error: File '%s' not found on server\n", filename)
 		return
 	}
-
-	// "Start <size>"
-	parts := strings.Fields(line)
-	if len(parts) != 2 || parts[0] != proto.ReponseStart {
-		slog.Error("Unexpected response from server", "received", line)
+	if strings.HasPrefix(line, proto.ReponseError+" ") {
+		fmt.Println(line)
 		return
 	}
 
-	size, err := strconv.ParseInt(parts[1], 10, 64)
+	size, chunked, encodage, err := lireStart(line)
 	if err != nil {
-		slog.Error("Invalid file size in response", "received", parts[1])
+		slog.Error("Unexpected response from server", "received", line, "error", err)
 		return
 	}
 
-	fmt.Printf("Downloading '%s' (%d bytes)...\n", filename, size)
+	// Creer le fichier localement. En cas d'echec, le contenu est quand meme
+	// lu jusqu'au bout pour que la connexion reste utilisable
+	localPath, outFile, errLocal := creerFichierLocal(filename)
+	out := &ecritureLocale{}
+	if errLocal != nil {
+		slog.Error("Failed to create local file", "file", filename, "error", errLocal)
+	} else {
+		defer outFile.Close()
+		out.w = outFile
+	}
 
-	// Creer le fichier localement
-	outFile, err := os.Create(filename)
+	var totalReceived int64
+	if chunked {
+		if encodage != "" {
+			fmt.Printf("Downloading '%s' (chunked, %s)...\n", filename, encodage)
+		} else {
+			fmt.Printf("Downloading '%s' (chunked)...\n", filename)
+		}
+		totalReceived, err = recevoirParBlocs(reader, out, encodage)
+	} else {
+		fmt.Printf("Downloading '%s' (%d bytes)...\n", filename, size)
+		totalReceived, err = recevoirTailleFixe(reader, out, size)
+	}
+	if err != nil && !errors.Is(err, errChecksum) {
+		// Flux interrompu : la suite de la connexion ne peut plus etre lue
+		slog.Error("Error receiving file data", "file", filename, "error", err, "received", totalReceived)
+		if outFile != nil {
+			outFile.Close()
+			os.Remove(localPath)
+		}
+		c.Close()
+		fmt.Println("Connection closed, the transfer could not be completed")
+		return
+	}
+	if err == nil {
+		err = errLocal
+	}
+	if err == nil {
+		err = out.err
+	}
 	if err != nil {
-		slog.Error("Failed to create local file", "file", filename, "error", err)
+		// Le flux est complet : le serveur attend la reponse du client
+		slog.Error("Failed to save file", "file", filename, "error", err, "received", totalReceived)
+		if outFile != nil {
+			outFile.Close()
+			os.Remove(localPath)
+		}
+		fmt.Fprintf(c, "%s\n", accuseReception(err))
 		return
 	}
-	defer outFile.Close()
 
-	// Lit exactement 'size' octets
-	buffer := make([]byte, 4096)
-	totalReceived := int64(0)
+	fmt.Printf("File '%s' downloaded successfully (%d bytes)\n", filename, totalReceived)
 
-	for totalReceived < size {
-		remaining := size - totalReceived
-		toRead := int64(len(buffer))
-		if remaining < toRead {
-			toRead = remaining
+	// Envoie OK au serveur
+	fmt.Fprintf(c, "%s\n", proto.ReponseOk)
+	slog.Debug("Sent OK confirmation for file transfer")
+}
+
+// lireStart analyse "Start <size>" ou "Start chunked [<encodage>]" ;
+// "Start chunked" signifie une taille inconnue, le contenu arrive par blocs.
+func lireStart(line string) (size int64, chunked bool, encodage string, err error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != proto.ReponseStart {
+		return 0, false, "", errors.New("unexpected response")
+	}
+	chunked = parts[1] == proto.ModeChunked
+	if len(parts) == 3 {
+		if !chunked {
+			return 0, false, "", errors.New("unexpected response")
 		}
+		encodage = parts[2]
+	}
+	if !chunked {
+		size, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, false, "", fmt.Errorf("invalid file size %q", parts[1])
+		}
+	}
+	return size, chunked, encodage, nil
+}
+
+// gererPut envoie le fichier local sous le nom remoteName dans le partage courant.
+func gererPut(c net.Conn, reader *bufio.Reader, localPath string, remoteName string) {
+	inFile, err := os.Open(localPath)
+	if err != nil {
+		fmt.Printf("Error: cannot open '%s': %v\n", localPath, err)
+		return
+	}
+	defer inFile.Close()
+	info, err := inFile.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		fmt.Printf("Error: '%s' is not a regular file\n", localPath)
+		return
+	}
+
+	if _, err := fmt.Fprintf(c, "%s %s %d\n", proto.CommandePut, remoteName, info.Size()); err != nil {
+		slog.Error("Failed to send command", "error", err)
+		return
+	}
+	// "OK" : le serveur accepte le fichier, sinon "QuotaExceeded ..." ou "Error ..."
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading server response", "error", err)
+		return
+	}
+	if line != proto.ReponseOk {
+		fmt.Println(line)
+		return
+	}
+
+	fmt.Printf("Uploading '%s' (%d bytes)...\n", remoteName, info.Size())
+	writer := bufio.NewWriter(c)
+	cw := sendrec.NewChunkedWriter(writer)
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(cw, h), inFile)
+	if err != nil {
+		// Le flux est termine quand meme : le serveur refusera le contenu incomplet
+		slog.Error("Error reading local file", "file", localPath, "error", err)
+	} else {
+		cw.Trailer(proto.TrailerSha256, hex.EncodeToString(h.Sum(nil)))
+	}
+	if err := cw.Close(); err != nil {
+		slog.Error("Failed to send file", "error", err)
+		return
+	}
+
+	line, err = lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading server response", "error", err)
+		return
+	}
+	if line != proto.ReponseOk {
+		fmt.Println(line)
+		return
+	}
+	fmt.Printf("File '%s' uploaded successfully (%d bytes)\n", remoteName, n)
+}
+
+// gererFollowReponse affiche le fichier suivi au fil de l'eau, jusqu'a la
+// prochaine ligne tapee par l'utilisateur (par exemple "Stop"), ou jusqu'a
+// la fin de la connexion si l'entree est terminee.
+func gererFollowReponse(c net.Conn, reader *bufio.Reader, console <-chan string, filename string) {
+	line, err := lireReponse(reader)
+	if err != nil {
+		slog.Error("Error reading server response", "error", err)
+		return
+	}
+	if line == proto.ReponseFileUnknown {
+		fmt.Printf("Error: File '%s' not found on server\n", filename)
+		return
+	}
+	if line != proto.ReponseStart+" "+proto.ModeChunked {
+		fmt.Println(line)
+		return
+	}
 
-		n, err := reader.Read(buffer[:toRead])
-		if n > 0 {
-			written, writeErr := outFile.Write(buffer[:n])
-			if writeErr != nil {
-				slog.Error("Failed to write to file", "error", writeErr)
+	// Le contenu est affiche tel quel, jusqu'au bloc de fin envoye apres Stop
+	fin := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(os.Stdout, sendrec.NewChunkedReader(reader))
+		fin <- err
+	}()
+	for {
+		select {
+		case err := <-fin:
+			if err != nil {
+				slog.Error("Follow ended", "file", filename, "error", err)
+			} else {
+				fmt.Printf("\nFollow of '%s' ended by server\n", filename)
+			}
+			return
+		case _, ok := <-console:
+			if !ok {
+				console = nil
+				continue
+			}
+			fmt.Fprintf(c, "%s\n", proto.CommandeStop)
+			if err := <-fin; err != nil {
+				slog.Error("Follow ended", "file", filename, "error", err)
 				return
 			}
-			totalReceived += int64(written)
+			fmt.Printf("\nFollow of '%s' stopped\n", filename)
+			return
 		}
+	}
+}
 
+// errChecksum signale un contenu recu qui ne correspond pas au trailer Sha256.
+var errChecksum = errors.New("checksum mismatch")
+
+// accuseReception retourne la reponse envoyee au serveur apres un contenu
+// recu en entier : OK, "Error checksum mismatch" si l'empreinte ne
+// correspond pas, ou "Error cannot save file" si le fichier local n'a pas pu
+// etre ecrit. Le serveur enregistre alors un echec.
+func accuseReception(err error) string {
+	switch {
+	case err == nil:
+		return proto.ReponseOk
+	case errors.Is(err, errChecksum):
+		return proto.ReponseError + " " + errChecksum.Error()
+	default:
+		return proto.ReponseError + " cannot save file"
+	}
+}
+
+// ecritureLocale ecrit le contenu recu dans w (nil = contenu ignore) sans
+// jamais echouer : la premiere erreur est gardee dans err, et la suite du
+// flux est lue sans etre ecrite.
+type ecritureLocale struct {
+	w   io.Writer
+	err error
+}
+
+func (e *ecritureLocale) Write(p []byte) (int, error) {
+	if e.w != nil && e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+	return len(p), nil
+}
+
+// recevoirTailleFixe lit exactement size octets et les ecrit dans out.
+func recevoirTailleFixe(reader *bufio.Reader, out io.Writer, size int64) (int64, error) {
+	n, err := io.CopyN(out, reader, size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// recevoirParBlocs lit un flux par blocs jusqu'au bloc de fin, le decompresse
+// si un encodage est indique, et verifie l'empreinte SHA-256 du contenu
+// si le serveur l'a envoyee en trailer.
+func recevoirParBlocs(reader *bufio.Reader, out io.Writer, encodage string) (int64, error) {
+	cr := sendrec.NewChunkedReader(reader)
+	var src io.Reader = cr
+	if encodage != "" {
+		zr, err := sendrec.NewDecompressor(encodage, cr)
 		if err != nil {
-			slog.Error("Error reading file data", "error", err, "received", totalReceived, "expected", size)
-			return
+			return 0, err
 		}
+		defer zr.Close()
+		src = zr
 	}
 
-	fmt.Printf("File '%s' downloaded successfully (%d bytes)\n", filename, totalReceived)
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), src)
+	if err != nil {
+		return n, err
+	}
+	// Le decompresseur peut s'arreter avant le bloc de fin : lire la suite
+	// pour consommer les trailers
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return n, err
+	}
 
-	// Envoie OK au serveur
-	fmt.Fprintf(c, "%s\n", proto.ReponseOk)
-	slog.Debug("Sent OK confirmation for file transfer")
-}
\ No newline at end of file
+	if expected, ok := cr.Trailers()[proto.TrailerSha256]; ok {
+		got := hex.EncodeToString(h.Sum(nil))
+		if got != expected {
+			return n, fmt.Errorf("%w: expected %s, got %s", errChecksum, expected, got)
+		}
+		slog.Debug("Checksum verified", "sha256", got)
+	}
+	return n, nil
+}
@@ -0,0 +1,456 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// Intervalle minimal entre deux affichages de l'avancement d'un fichier
+const intervalleProgression = time.Second
+
+// Suffixe d'un fichier en cours de telechargement (modes get et sync),
+// renomme une fois complet
+const suffixeTelechargement = ".part"
+
+// fichierDistant est un fichier du partage, tel que liste par le serveur.
+type fichierDistant struct {
+	nom    string
+	taille int64
+	modif  time.Time
+}
+
+// tacheFichier est un fichier a telecharger et le nombre d'echecs deja subis.
+type tacheFichier struct {
+	f      fichierDistant
+	echecs int
+}
+
+type resultatFichier struct {
+	t      tacheFichier
+	octets int64
+	duree  time.Duration
+	err    error
+}
+
+// Download telecharge les fichiers designes par noms (noms ou motifs de
+// path.Match, compares a la liste du partage) avec un groupe de
+// options.Workers connexions. Un fichier qui echoue est retente jusqu'a
+// options.Retries fois, eventuellement sur une autre connexion ; un fichier
+// deja present localement avec la taille du serveur est ignore.
+// Retourne false si un fichier n'a pas pu etre telecharge.
+func Download(remote string, options Options, noms []string) bool {
+	// Une premiere connexion liste le partage, puis rejoint le groupe
+	conn, reader, err := connecter(remote, options.Share)
+	if err != nil {
+		slog.Error("Failed to connect", "error", err)
+		return false
+	}
+	liste, err := listerFichiers(conn, reader)
+	if err != nil {
+		slog.Error("Failed to list share", "share", options.Share, "error", err)
+		fmt.Fprintf(conn, "%s\n", proto.CommandeEnd)
+		conn.Close()
+		return false
+	}
+
+	fichiers, absents := choisirFichiers(liste, noms)
+	ignores := 0
+	echecs := make(map[string]error)
+	for _, nom := range absents {
+		fmt.Printf("%s: not found on server\n", nom)
+		echecs[nom] = errFichierInconnu
+	}
+
+	var aTelecharger []fichierDistant
+	for _, f := range fichiers {
+		localPath, err := cheminLocal(".", f.nom)
+		if err != nil {
+			fmt.Printf("%s: failed (%v)\n", f.nom, err)
+			echecs[f.nom] = err
+			continue
+		}
+		if info, err := os.Stat(localPath); err == nil && info.Mode().IsRegular() && info.Size() == f.taille {
+			fmt.Printf("%s: skipped, already downloaded\n", f.nom)
+			ignores++
+			continue
+		}
+		aTelecharger = append(aTelecharger, f)
+	}
+	reussis, erreurs := telechargerFichiers(remote, options, conn, reader, aTelecharger, ".")
+	for nom, err := range erreurs {
+		echecs[nom] = err
+	}
+
+	fmt.Printf("Summary: %d succeeded, %d failed, %d skipped\n", reussis, len(echecs), ignores)
+	return len(echecs) == 0
+}
+
+// telechargerFichiers telecharge les fichiers dans dossier avec un groupe de
+// options.Workers connexions, dont conn (deja ouverte sur le partage). Un
+// fichier qui echoue est retente jusqu'a options.Retries fois, eventuellement
+// sur une autre connexion. Retourne le nombre de fichiers telecharges et
+// l'erreur de chaque fichier en echec.
+func telechargerFichiers(remote string, options Options, conn net.Conn, reader *bufio.Reader, fichiers []fichierDistant, dossier string) (int, map[string]error) {
+	taches := make(chan tacheFichier, len(fichiers))
+	for _, f := range fichiers {
+		taches <- tacheFichier{f: f}
+	}
+	restants := len(taches)
+	total := restants
+	reussis := 0
+	echecs := make(map[string]error)
+
+	resultats := make(chan resultatFichier)
+	travailleurs := min(options.Workers, max(restants, 1))
+	finis := make(chan struct{}, travailleurs)
+	attente := newConnexionsEnAttente()
+	var wg sync.WaitGroup
+	wg.Add(travailleurs)
+	for i := 0; i < travailleurs; i++ {
+		go func(conn net.Conn, reader *bufio.Reader) {
+			defer wg.Done()
+			defer func() { finis <- struct{}{} }()
+			telechargerTaches(remote, options.Share, conn, reader, attente, taches, resultats, dossier)
+		}(conn, reader)
+		conn, reader = nil, nil
+	}
+
+	actifs := travailleurs
+	for restants > 0 && actifs > 0 {
+		var r resultatFichier
+		select {
+		case r = <-resultats:
+		case <-finis:
+			actifs--
+			continue
+		}
+		if r.err == nil {
+			reussis++
+			restants--
+			fmt.Printf("[%d/%d] %s: done (%d bytes in %s)\n", total-restants, total, r.t.f.nom, r.octets, r.duree.Round(time.Millisecond))
+			continue
+		}
+		r.t.echecs++
+		if r.t.echecs <= options.Retries && !errors.Is(r.err, errFichierInconnu) {
+			fmt.Printf("%s: failed (%v), retrying (%d/%d)\n", r.t.f.nom, r.err, r.t.echecs, options.Retries)
+			taches <- r.t
+			continue
+		}
+		restants--
+		fmt.Printf("[%d/%d] %s: failed (%v)\n", total-restants, total, r.t.f.nom, r.err)
+		echecs[r.t.f.nom] = r.err
+	}
+	close(taches)
+	// Plus aucune connexion : les fichiers restants ne sont pas telecharges
+	for t := range taches {
+		fmt.Printf("%s: failed (no connection to server)\n", t.f.nom)
+		echecs[t.f.nom] = errors.New("no connection to server")
+	}
+	attente.couper()
+	wg.Wait()
+	return reussis, echecs
+}
+
+// telechargerTaches telecharge les fichiers de taches sur une connexion,
+// ouverte si besoin (conn peut etre nil) avant de prendre un fichier : une
+// connexion en attente d'une place sur le serveur ne retient aucun fichier.
+// Apres un echec, l'etat de la connexion est inconnu : une nouvelle
+// connexion sert au fichier suivant.
+func telechargerTaches(remote string, partage string, conn net.Conn, reader *bufio.Reader, attente *connexionsEnAttente, taches <-chan tacheFichier, resultats chan<- resultatFichier, dossier string) {
+	defer func() {
+		if conn != nil {
+			fmt.Fprintf(conn, "%s\n", proto.CommandeEnd)
+			conn.Close()
+		}
+	}()
+	for {
+		if conn == nil {
+			var err error
+			if conn, reader, err = attente.connecter(remote, partage); err != nil {
+				if !errors.Is(err, errConnexionInutile) {
+					slog.Warn("Failed to open download connection", "error", err)
+				}
+				return
+			}
+		}
+		t, ok := <-taches
+		if !ok {
+			return
+		}
+		debut := time.Now()
+		n, err := telechargerFichier(conn, reader, t.f, dossier)
+		if err != nil && !errors.Is(err, errFichierInconnu) {
+			conn.Close()
+			conn = nil
+		}
+		resultats <- resultatFichier{t: t, octets: n, duree: time.Since(debut), err: err}
+	}
+}
+
+// telechargerFichier telecharge un fichier (Get) dans dossier, en affichant
+// son avancement. Le contenu est ecrit a cote du fichier local, qui n'est
+// remplace qu'une fois le telechargement reussi ; le fichier garde la date
+// de modification du serveur.
+func telechargerFichier(c net.Conn, reader *bufio.Reader, f fichierDistant, dossier string) (int64, error) {
+	localPath, err := cheminLocal(dossier, f.nom)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(c, "%s %s %s\n", proto.CommandeGet, f.nom, encodagesAcceptes); err != nil {
+		return 0, err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return 0, err
+	}
+	if line == proto.ReponseFileUnknown {
+		return 0, errFichierInconnu
+	}
+	size, chunked, encodage, err := lireStart(line)
+	if err != nil {
+		return 0, errors.New(line)
+	}
+
+	partPath, outFile, err := creerFichierLocal(localPath + suffixeTelechargement)
+	if err != nil {
+		return 0, err
+	}
+	defer outFile.Close()
+	fmt.Printf("%s: downloading (%d bytes)\n", f.nom, f.taille)
+	out := &ecrivainProgression{w: outFile, nom: f.nom, total: f.taille, dernier: time.Now()}
+
+	var n int64
+	if chunked {
+		n, err = recevoirParBlocs(reader, out, encodage)
+	} else {
+		n, err = recevoirTailleFixe(reader, out, size)
+	}
+	if err != nil {
+		outFile.Close()
+		os.Remove(partPath)
+		if errors.Is(err, errChecksum) {
+			// Le flux est complet : le serveur attend la reponse du client
+			fmt.Fprintf(c, "%s\n", accuseReception(err))
+		}
+		return n, err
+	}
+	if _, err := fmt.Fprintf(c, "%s\n", proto.ReponseOk); err != nil {
+		outFile.Close()
+		os.Remove(partPath)
+		return n, err
+	}
+
+	err = outFile.Close()
+	if err == nil {
+		err = os.Rename(partPath, localPath)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return n, err
+	}
+	if !f.modif.IsZero() {
+		if err := os.Chtimes(localPath, time.Now(), f.modif); err != nil {
+			slog.Warn("Failed to set file date", "file", localPath, "error", err)
+		}
+	}
+	return n, nil
+}
+
+// ecrivainProgression affiche l'avancement d'un telechargement, au plus une
+// fois par intervalleProgression.
+type ecrivainProgression struct {
+	w       io.Writer
+	nom     string
+	total   int64
+	n       int64
+	dernier time.Time
+}
+
+func (e *ecrivainProgression) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	e.n += int64(n)
+	if time.Since(e.dernier) >= intervalleProgression && e.total > 0 {
+		e.dernier = time.Now()
+		fmt.Printf("%s: %d%% (%d/%d bytes)\n", e.nom, e.n*100/e.total, e.n, e.total)
+	}
+	return n, err
+}
+
+// errNomInvalide signale un nom envoye par le serveur qui sortirait du
+// dossier local (chemin absolu, "..").
+var errNomInvalide = errors.New("invalid file name from server")
+
+// cheminLocal retourne le chemin local du fichier nom (recu du serveur) dans
+// dossier. Le nom doit etre relatif et rester dans dossier.
+func cheminLocal(dossier string, nom string) (string, error) {
+	if !fs.ValidPath(nom) || nom == "." || !filepath.IsLocal(filepath.FromSlash(nom)) {
+		return "", fmt.Errorf("%w: %q", errNomInvalide, nom)
+	}
+	chemin := filepath.Join(dossier, filepath.FromSlash(nom))
+	rel, err := filepath.Rel(dossier, chemin)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %q", errNomInvalide, nom)
+	}
+	return chemin, nil
+}
+
+// connecter ouvre une connexion au serveur et selectionne le partage.
+func connecter(remote string, partage string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("tcp", remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if err := choisirPartage(conn, reader, partage); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+// errConnexionInutile signale une connexion coupee avant d'etre admise,
+// parce que le travail est termine.
+var errConnexionInutile = errors.New("connection no longer needed")
+
+// connexionsEnAttente ouvre des connexions supplementaires, et coupe celles
+// qui attendent encore une place sur le serveur quand elles ne servent plus.
+type connexionsEnAttente struct {
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	coupee bool
+}
+
+func newConnexionsEnAttente() *connexionsEnAttente {
+	return &connexionsEnAttente{conns: make(map[net.Conn]bool)}
+}
+
+// connecter ouvre une connexion et selectionne le partage ; la reponse n'arrive
+// qu'une fois la connexion admise par le serveur. Retourne errConnexionInutile
+// apres couper.
+func (a *connexionsEnAttente) connecter(remote string, partage string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("tcp", remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.mu.Lock()
+	if a.coupee {
+		a.mu.Unlock()
+		conn.Close()
+		return nil, nil, errConnexionInutile
+	}
+	a.conns[conn] = true
+	a.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	err = choisirPartage(conn, reader, partage)
+	a.mu.Lock()
+	delete(a.conns, conn)
+	if err != nil && a.coupee {
+		err = errConnexionInutile
+	}
+	a.mu.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+// couper ferme les connexions pas encore admises ; les suivantes ne sont plus ouvertes.
+func (a *connexionsEnAttente) couper() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.coupee = true
+	for conn := range a.conns {
+		conn.Close()
+	}
+}
+
+// listerFichiers retourne les fichiers du partage courant, avec leur date (List long).
+func listerFichiers(c net.Conn, reader *bufio.Reader) ([]fichierDistant, error) {
+	if _, err := fmt.Fprintf(c, "%s %s\n", proto.CommandeList, proto.ListeDetaillee); err != nil {
+		return nil, err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 2 || parts[0] != proto.ReponseFileCount {
+		return nil, errors.New(line)
+	}
+	count, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid file count %q", parts[1])
+	}
+
+	fichiers := make([]fichierDistant, 0, count)
+	for i := 0; i < count; i++ {
+		fileLine, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		// "<nom> <taille> <date>", lu depuis la fin : le nom peut contenir des espaces
+		ligne := strings.TrimSpace(fileLine)
+		i := strings.LastIndexByte(ligne, ' ')
+		j := -1
+		if i > 0 {
+			j = strings.LastIndexByte(ligne[:i], ' ')
+		}
+		if j <= 0 {
+			return nil, fmt.Errorf("invalid file line %q", fileLine)
+		}
+		size, err := strconv.ParseInt(ligne[j+1:i], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file line %q", fileLine)
+		}
+		modif, err := time.Parse(time.RFC3339Nano, ligne[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid file line %q", fileLine)
+		}
+		fichiers = append(fichiers, fichierDistant{nom: ligne[:j], taille: size, modif: modif})
+	}
+	if _, err := fmt.Fprintf(c, "%s\n", proto.ReponseOk); err != nil {
+		return nil, err
+	}
+	return fichiers, nil
+}
+
+// choisirFichiers retourne les fichiers de la liste designes par noms (noms
+// ou motifs), chacun une seule fois, et les noms sans fichier correspondant.
+func choisirFichiers(liste []fichierDistant, noms []string) ([]fichierDistant, []string) {
+	var fichiers []fichierDistant
+	var absents []string
+	choisis := make(map[string]bool)
+	for _, nom := range noms {
+		trouve := false
+		for _, f := range liste {
+			if ok, _ := path.Match(nom, f.nom); !ok {
+				continue
+			}
+			trouve = true
+			if !choisis[f.nom] {
+				choisis[f.nom] = true
+				fichiers = append(fichiers, f)
+			}
+		}
+		if !trouve {
+			absents = append(absents, nom)
+		}
+	}
+	return fichiers, absents
+}
@@ -0,0 +1,321 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+const (
+	// Taille minimale d'une plage : un fichier plus petit que deux plages
+	// est telecharge sur une seule connexion
+	taillePlageMin = 1 << 20
+	// Plages par connexion : les connexions les plus rapides en prennent plus
+	plagesParConnexion = 4
+	// Tentatives par plage avant d'abandonner le telechargement
+	essaisPlage = 3
+)
+
+// errFichierInconnu signale un fichier absent du partage (FileUnknown).
+var errFichierInconnu = errors.New("file not found on server")
+
+// plage est une partie du fichier a telecharger : de debut (inclus) a fin (exclue).
+type plage struct {
+	debut, fin int64
+	essais     int
+}
+
+type resultatPlage struct {
+	p   plage
+	err error
+}
+
+// gererGetParallele telecharge un fichier en repartissant ses plages entre
+// parallele connexions, puis verifie l'empreinte du fichier complet.
+// Un petit fichier, ou un fichier dont le serveur ne donne pas l'empreinte,
+// est telecharge normalement sur la connexion c.
+// Retourne la connexion a utiliser pour les commandes suivantes : c, ou une
+// nouvelle connexion sur le meme partage si une plage a echoue sur c (son
+// etat est alors inconnu).
+func gererGetParallele(c net.Conn, reader *bufio.Reader, remote string, partage string, filename string, parallele int) (net.Conn, *bufio.Reader) {
+	taille, empreinte, err := demanderEmpreinte(c, reader, filename)
+	if errors.Is(err, errFichierInconnu) {
+		fmt.Printf("Error: File '%s' not found on server\n", filename)
+		return c, reader
+	}
+	if err != nil || taille < 2*taillePlageMin {
+		if err != nil {
+			slog.Debug("No hash for file, using a single connection", "file", filename, "error", err)
+		}
+		if _, err := fmt.Fprintf(c, "%s %s %s\n", proto.CommandeGet, filename, encodagesAcceptes); err != nil {
+			slog.Error("Failed to send command", "error", err)
+			return c, reader
+		}
+		gererGetReponse(c, reader, filename)
+		return c, reader
+	}
+
+	localPath, outFile, err := creerFichierLocal(filename)
+	if err != nil {
+		slog.Error("Failed to create local file", "file", filename, "error", err)
+		return c, reader
+	}
+	defer outFile.Close()
+	if err := outFile.Truncate(taille); err != nil {
+		slog.Error("Failed to create local file", "file", filename, "error", err)
+		return c, reader
+	}
+
+	fmt.Printf("Downloading '%s' (%d bytes, %d connections)...\n", filename, taille, parallele)
+	utilisable, err := telechargerPlages(c, reader, remote, partage, filename, outFile, taille, parallele)
+	if err == nil {
+		err = verifierEmpreinte(outFile, empreinte)
+	}
+	if err != nil {
+		slog.Error("Error receiving file data", "file", filename, "error", err)
+		outFile.Close()
+		os.Remove(localPath)
+	} else {
+		fmt.Printf("File '%s' downloaded successfully (%d bytes)\n", filename, taille)
+	}
+
+	if utilisable {
+		return c, reader
+	}
+	// Une plage a echoue sur c : la suite de la connexion ne peut plus etre lue
+	c.Close()
+	conn, r, err := connecter(remote, partage)
+	if err != nil {
+		slog.Error("Failed to reconnect", "error", err)
+		fmt.Println("Connection closed, the transfer could not be completed")
+		return c, reader
+	}
+	slog.Info("Reconnected after a failed range", "share", partage)
+	return conn, r
+}
+
+// demanderEmpreinte retourne la taille et l'empreinte SHA-256 du fichier (Hash).
+func demanderEmpreinte(c net.Conn, reader *bufio.Reader, filename string) (int64, string, error) {
+	if _, err := fmt.Fprintf(c, "%s %s\n", proto.CommandeHash, filename); err != nil {
+		return 0, "", err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return 0, "", err
+	}
+	if line == proto.ReponseFileUnknown {
+		return 0, "", errFichierInconnu
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || parts[0] != proto.ReponseHash {
+		return 0, "", errors.New(line)
+	}
+	taille, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid file size in response: %s", parts[1])
+	}
+	return taille, parts[2], nil
+}
+
+// telechargerPlages ecrit le fichier dans out, plage par plage. La connexion c
+// et parallele-1 nouvelles connexions prennent les plages une a une ; une
+// plage qui echoue est reprise par une autre connexion. Les nouvelles
+// connexions qui attendent une place sur le serveur (file d'attente)
+// n'empechent pas la fin du telechargement.
+// Retourne aussi si c est encore utilisable : faux si une plage y a echoue.
+func telechargerPlages(c net.Conn, reader *bufio.Reader, remote string, partage string, filename string, out *os.File, taille int64, parallele int) (bool, error) {
+	nb := min(int64(parallele*plagesParConnexion), taille/taillePlageMin)
+	longueur := (taille + nb - 1) / nb
+	plages := make(chan plage, nb)
+	restantes := 0
+	for debut := int64(0); debut < taille; debut += longueur {
+		plages <- plage{debut: debut, fin: min(debut+longueur, taille)}
+		restantes++
+	}
+
+	resultats := make(chan resultatPlage)
+	arret := make(chan struct{})
+	finis := make(chan struct{}, parallele)
+	// travailler retourne faux si la connexion n'est plus utilisable
+	travailler := func(conn net.Conn, reader *bufio.Reader) bool {
+		for {
+			select {
+			case <-arret:
+				return true
+			case p := <-plages:
+				err := recevoirPlage(conn, reader, out, filename, p)
+				select {
+				case resultats <- resultatPlage{p: p, err: err}:
+				case <-arret:
+					return err == nil
+				}
+				// Apres une erreur, l'etat de la connexion est inconnu
+				if err != nil {
+					return false
+				}
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	attente := newConnexionsEnAttente()
+	wg.Add(parallele)
+	utilisable := true
+	go func() {
+		defer wg.Done()
+		defer func() { finis <- struct{}{} }()
+		utilisable = travailler(c, reader)
+	}()
+	for i := 1; i < parallele; i++ {
+		go func() {
+			defer wg.Done()
+			defer func() { finis <- struct{}{} }()
+			conn, r, err := attente.connecter(remote, partage)
+			if err != nil {
+				if !errors.Is(err, errConnexionInutile) {
+					slog.Warn("Failed to open download connection", "error", err)
+				}
+				return
+			}
+			defer conn.Close()
+			travailler(conn, r)
+			fmt.Fprintf(conn, "%s\n", proto.CommandeEnd)
+		}()
+	}
+
+	var erreur error
+	actifs := parallele
+	for restantes > 0 && actifs > 0 && erreur == nil {
+		select {
+		case r := <-resultats:
+			if r.err == nil {
+				restantes--
+				slog.Debug("Range received", "file", filename, "start", r.p.debut, "end", r.p.fin, "remaining", restantes)
+				continue
+			}
+			slog.Warn("Range download failed", "file", filename, "start", r.p.debut, "end", r.p.fin, "error", r.err)
+			if r.p.essais++; r.p.essais >= essaisPlage {
+				erreur = r.err
+				continue
+			}
+			plages <- r.p
+		case <-finis:
+			actifs--
+		}
+	}
+
+	// Les connexions encore en attente d'une place sont coupees ; les autres
+	// terminent leur plage en cours
+	close(arret)
+	attente.couper()
+	wg.Wait()
+
+	if erreur != nil {
+		return utilisable, erreur
+	}
+	if restantes > 0 {
+		return utilisable, errors.New("no download connection left")
+	}
+	return utilisable, nil
+}
+
+// choisirPartage selectionne le partage sur une nouvelle connexion. La
+// reponse n'arrive qu'une fois la connexion admise par le serveur.
+func choisirPartage(conn net.Conn, reader *bufio.Reader, partage string) error {
+	if _, err := fmt.Fprintf(conn, "%s %s\n", proto.CommandeUse, partage); err != nil {
+		return err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return err
+	}
+	if line != proto.ReponseOk {
+		return errors.New(line)
+	}
+	return nil
+}
+
+// recevoirPlage telecharge la plage p du fichier et l'ecrit a sa place dans out.
+func recevoirPlage(conn net.Conn, reader *bufio.Reader, out *os.File, filename string, p plage) error {
+	if _, err := fmt.Fprintf(conn, "%s %s %s%d-%d\n", proto.CommandeGet, filename, proto.PrefixePlage, p.debut, p.fin); err != nil {
+		return err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return err
+	}
+	if line == proto.ReponseFileUnknown {
+		return errFichierInconnu
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 2 || parts[0] != proto.ReponseStart {
+		return errors.New(line)
+	}
+
+	w := io.NewOffsetWriter(out, p.debut)
+	var n int64
+	if parts[1] == proto.ModeChunked {
+		n, err = recevoirParBlocs(reader, w, "")
+	} else {
+		size, perr := strconv.ParseInt(parts[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid range size in response: %s", parts[1])
+		}
+		n, err = recevoirTailleFixe(reader, w, size)
+	}
+	if err != nil && !errors.Is(err, errChecksum) {
+		return err
+	}
+	// Le flux est complet : le serveur attend la reponse, meme si la plage est refusee
+	if _, errOk := fmt.Fprintf(conn, "%s\n", accuseReception(err)); errOk != nil {
+		return errOk
+	}
+	if err != nil {
+		return err
+	}
+	if n != p.fin-p.debut {
+		return fmt.Errorf("range %d-%d: received %d bytes", p.debut, p.fin, n)
+	}
+	return nil
+}
+
+// verifierEmpreinte compare l'empreinte SHA-256 du fichier a celle du serveur.
+func verifierEmpreinte(f *os.File, attendue string) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != attendue {
+		return fmt.Errorf("%w: expected %s, got %s", errChecksum, attendue, got)
+	}
+	slog.Debug("Checksum verified", "sha256", attendue)
+	return nil
+}
+
+// creerFichierLocal cree le fichier telecharge ("dossier/fichier" pour un
+// fichier d'une archive) et retourne son chemin local.
+func creerFichierLocal(filename string) (string, *os.File, error) {
+	localPath := filepath.FromSlash(filename)
+	if dir := filepath.Dir(localPath); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			slog.Error("Failed to create local directory", "dir", dir, "error", err)
+		}
+	}
+	f, err := os.Create(localPath)
+	return localPath, f, err
+}
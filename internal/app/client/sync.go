@@ -0,0 +1,208 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// Sync met le dossier local en miroir du partage : les fichiers nouveaux ou
+// modifies sont telecharges (avec le groupe de connexions du mode get), et
+// avec options.Delete les fichiers locaux absents du serveur sont supprimes.
+//
+// Un fichier de meme taille et de meme date que sur le serveur est considere
+// inchange ; si seule la date differe (ou avec options.Checksum), les
+// empreintes SHA-256 sont comparees (Hash). Avec options.DryRun, les actions
+// prevues sont affichees sans rien modifier.
+// Retourne false si le miroir n'a pas pu etre mis a jour entierement.
+func Sync(remote string, options Options, dossier string) bool {
+	if info, err := os.Stat(dossier); err == nil && !info.IsDir() {
+		slog.Error("Sync target is not a directory", "dir", dossier)
+		return false
+	}
+
+	conn, reader, err := connecter(remote, options.Share)
+	if err != nil {
+		slog.Error("Failed to connect", "error", err)
+		return false
+	}
+	terminer := func() {
+		fmt.Fprintf(conn, "%s\n", proto.CommandeEnd)
+		conn.Close()
+	}
+	liste, err := listerFichiers(conn, reader)
+	if err != nil {
+		slog.Error("Failed to list share", "share", options.Share, "error", err)
+		terminer()
+		return false
+	}
+	locaux, err := lireDossierLocal(dossier)
+	if err != nil {
+		slog.Error("Failed to read local directory", "dir", dossier, "error", err)
+		terminer()
+		return false
+	}
+
+	// Comparaison : fichiers a telecharger, a redater, a supprimer
+	var aTelecharger, aRedater []fichierDistant
+	var aSupprimer []string
+	nouveaux, modifies, inchanges, ignores := 0, 0, 0, 0
+	distants := make(map[string]bool, len(liste))
+	for _, f := range liste {
+		localPath, err := cheminLocal(dossier, f.nom)
+		if err != nil {
+			fmt.Printf("skip     %s (invalid name)\n", f.nom)
+			ignores++
+			continue
+		}
+		distants[f.nom] = true
+		local, ok := locaux[f.nom]
+		switch {
+		case strings.ContainsAny(f.nom, " \t"):
+			// Les commandes sont decoupees aux espaces : ce fichier ne peut
+			// pas etre demande (sa copie locale n'est pas supprimee)
+			fmt.Printf("skip     %s (name contains a space)\n", f.nom)
+			ignores++
+		case !ok:
+			fmt.Printf("new      %s (%d bytes)\n", f.nom, f.taille)
+			aTelecharger = append(aTelecharger, f)
+			nouveaux++
+		case local.Size() != f.taille:
+			fmt.Printf("changed  %s (size %d -> %d)\n", f.nom, local.Size(), f.taille)
+			aTelecharger = append(aTelecharger, f)
+			modifies++
+		case options.Checksum || !local.ModTime().Equal(f.modif):
+			identique, err := memeContenu(conn, reader, localPath, f.nom)
+			if err != nil {
+				fmt.Printf("changed  %s (cannot compare: %v)\n", f.nom, err)
+			} else if !identique {
+				fmt.Printf("changed  %s (content differs)\n", f.nom)
+			}
+			if err != nil || !identique {
+				aTelecharger = append(aTelecharger, f)
+				modifies++
+				continue
+			}
+			if !local.ModTime().Equal(f.modif) {
+				aRedater = append(aRedater, f)
+			}
+			inchanges++
+		default:
+			inchanges++
+		}
+	}
+	if options.Delete {
+		for nom := range locaux {
+			if !distants[nom] {
+				aSupprimer = append(aSupprimer, nom)
+			}
+		}
+		sort.Strings(aSupprimer)
+		for _, nom := range aSupprimer {
+			fmt.Printf("delete   %s\n", nom)
+		}
+	}
+	fmt.Printf("Plan: %d new, %d changed, %d to delete, %d unchanged, %d skipped\n", nouveaux, modifies, len(aSupprimer), inchanges, ignores)
+	if options.DryRun {
+		terminer()
+		return true
+	}
+
+	// Contenu identique : seule la date locale est mise a jour
+	for _, f := range aRedater {
+		localPath, err := cheminLocal(dossier, f.nom)
+		if err != nil {
+			continue
+		}
+		if err := os.Chtimes(localPath, time.Now(), f.modif); err != nil {
+			slog.Warn("Failed to set file date", "file", localPath, "error", err)
+		}
+	}
+
+	reussis, echecs := telechargerFichiers(remote, options, conn, reader, aTelecharger, dossier)
+	supprimes := 0
+	for _, nom := range aSupprimer {
+		localPath, err := cheminLocal(dossier, nom)
+		if err == nil {
+			err = os.Remove(localPath)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Printf("%s: delete failed (%v)\n", nom, err)
+			echecs[nom] = err
+			continue
+		}
+		supprimes++
+		// Les sous-dossiers devenus vides sont supprimes aussi
+		for d := path.Dir(nom); d != "."; d = path.Dir(d) {
+			sousDossier, err := cheminLocal(dossier, d)
+			if err != nil || os.Remove(sousDossier) != nil {
+				break
+			}
+		}
+	}
+
+	fmt.Printf("Summary: %d downloaded, %d failed, %d deleted, %d unchanged, %d skipped\n", reussis, len(echecs), supprimes, inchanges, ignores)
+	return len(echecs) == 0
+}
+
+// lireDossierLocal retourne les fichiers de dossier et de ses sous-dossiers,
+// par chemin relatif ("dossier/fichier"). Un dossier absent est vide.
+// Les telechargements interrompus (.part) sont ignores.
+func lireDossierLocal(dossier string) (map[string]fs.FileInfo, error) {
+	fichiers := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(dossier, func(chemin string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if chemin == dossier && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), suffixeTelechargement) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dossier, chemin)
+		if err != nil {
+			return err
+		}
+		fichiers[filepath.ToSlash(rel)] = info
+		return nil
+	})
+	return fichiers, err
+}
+
+// memeContenu compare l'empreinte du fichier local a celle du fichier nom sur
+// le serveur (Hash).
+func memeContenu(c net.Conn, reader *bufio.Reader, localPath string, nom string) (bool, error) {
+	_, empreinte, err := demanderEmpreinte(c, reader, nom)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return hex.EncodeToString(h.Sum(nil)) == empreinte, nil
+}
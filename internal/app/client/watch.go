@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// Event est un changement dans le partage observe avec Watch.
+type Event struct {
+	// proto.EvenementAjout, EvenementSuppression, EvenementModification,
+	// EvenementCache ou EvenementRevele ; proto.EvenementResync (sans Name)
+	// si des changements ont ete perdus et que la liste doit etre relue
+	Type string
+	Name string
+}
+
+// Watcher recoit les changements d'un partage (commande Watch).
+type Watcher struct {
+	// Events est ferme a la fin du mode Watch : apres Stop, ou si la
+	// connexion est coupee (voir Err)
+	Events <-chan Event
+
+	conn   net.Conn
+	events chan Event
+	fini   chan struct{}
+	err    error
+}
+
+// Watch passe la connexion en mode Watch : le serveur envoie les changements
+// des fichiers du partage courant correspondant a pattern (tous si vide).
+// Aucune autre commande ne peut etre envoyee avant Stop.
+func Watch(conn net.Conn, reader *bufio.Reader, pattern string) (*Watcher, error) {
+	cmd := proto.CommandeWatch
+	if pattern != "" {
+		cmd += " " + pattern
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", cmd); err != nil {
+		return nil, err
+	}
+	line, err := lireReponse(reader)
+	if err != nil {
+		return nil, err
+	}
+	if line != proto.ReponseOk {
+		return nil, errors.New(line)
+	}
+
+	w := &Watcher{conn: conn, events: make(chan Event), fini: make(chan struct{})}
+	w.Events = w.events
+	go w.recevoir(reader)
+	return w, nil
+}
+
+// recevoir lit les evenements jusqu'au OK qui repond a Stop.
+func (w *Watcher) recevoir(reader *bufio.Reader) {
+	defer close(w.fini)
+	defer close(w.events)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("connection closed by server")
+			}
+			w.err = err
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == proto.ReponseOk {
+			return
+		}
+		if line == proto.EvenementResync {
+			w.events <- Event{Type: line}
+			continue
+		}
+		// "Error ..." : ligne inattendue envoyee pendant Watch
+		typ, name, ok := strings.Cut(line, " ")
+		if !ok || typ == proto.ReponseError {
+			slog.Warn("Unexpected message during Watch", "received", line)
+			continue
+		}
+		w.events <- Event{Type: typ, Name: name}
+	}
+}
+
+// Stop termine le mode Watch ; la connexion peut ensuite servir a d'autres
+// commandes. Les evenements pas encore lus sont ignores.
+func (w *Watcher) Stop() error {
+	select {
+	case <-w.fini:
+		return w.err
+	default:
+	}
+	if _, err := fmt.Fprintf(w.conn, "%s\n", proto.CommandeStop); err != nil {
+		return err
+	}
+	for range w.events {
+	}
+	<-w.fini
+	return w.err
+}
+
+// Err retourne l'erreur qui a termine le mode Watch (nil apres Stop).
+// A appeler une fois Events ferme.
+func (w *Watcher) Err() error {
+	<-w.fini
+	return w.err
+}
+
+// gererWatch affiche les changements jusqu'a la prochaine ligne tapee par
+// l'utilisateur (par exemple "Stop"), ou jusqu'a la fin de la connexion si
+// l'entree est terminee.
+func gererWatch(c net.Conn, reader *bufio.Reader, console <-chan string, pattern string) {
+	w, err := Watch(c, reader, pattern)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Watching for changes (type Stop to end)...")
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				slog.Error("Watch ended", "error", w.Err())
+				return
+			}
+			if ev.Type == proto.EvenementResync {
+				fmt.Println("Some changes were lost, use List to see the current files")
+				continue
+			}
+			fmt.Printf("%s %s\n", ev.Type, ev.Name)
+		case _, ok := <-console:
+			if !ok {
+				// Plus d'entree : surveiller jusqu'a la fin de la connexion
+				console = nil
+				continue
+			}
+			if err := w.Stop(); err != nil {
+				slog.Error("Watch ended", "error", err)
+				return
+			}
+			fmt.Println("Watch stopped")
+			return
+		}
+	}
+}
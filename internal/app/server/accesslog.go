@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Resultats possibles d'une commande (journal d'acces et metriques)
+const (
+	resultatOk          = "ok"          // fichier envoye et confirme par le client
+	resultatInconnu     = "unknown"     // FileUnknown (absent, cache ou dossier)
+	resultatErreur      = "error"       // erreur pendant l'envoi
+	resultatNonConfirme = "unconfirmed" // fichier envoye mais pas de OK du client
+	resultatRefuse      = "rejected"    // fichier envoye mais refuse par le client (empreinte differente)
+	resultatInvalide    = "invalid"     // commande mal formee
+	resultatQuota       = "quota"       // envoi refuse, quota depasse
+)
+
+// sessionRecord est l'enregistrement d'une session dans le journal d'acces.
+type sessionRecord struct {
+	Session      string           `json:"session"`
+	Connected    time.Time        `json:"connected"`
+	Disconnected time.Time        `json:"disconnected"`
+	Client       string           `json:"client"`
+	Downloads    []downloadRecord `json:"downloads"`
+	// Modifications des partages (Delete, Rename, Mkdir)
+	Operations []operationRecord `json:"operations,omitempty"`
+}
+
+// downloadRecord decrit une commande Get de la session.
+type downloadRecord struct {
+	File       string `json:"file"`
+	Size       int64  `json:"size"`
+	DurationMs int64  `json:"duration_ms"`
+	Outcome    string `json:"outcome"`
+	Encoding   string `json:"encoding,omitempty"`
+}
+
+// operationRecord decrit une modification d'un partage.
+type operationRecord struct {
+	Operation string    `json:"operation"`
+	Share     string    `json:"share"`
+	File      string    `json:"file"`
+	Target    string    `json:"target,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Time      time.Time `json:"time"`
+}
+
+func newSessionRecord(id string, client string) *sessionRecord {
+	return &sessionRecord{Session: id, Connected: time.Now(), Client: client, Downloads: []downloadRecord{}}
+}
+
+// telechargement ajoute un Get a la session.
+func (r *sessionRecord) telechargement(file string, size int64, debut time.Time, resultat string, encodage string) {
+	r.Downloads = append(r.Downloads, downloadRecord{
+		File:       file,
+		Size:       size,
+		DurationMs: time.Since(debut).Milliseconds(),
+		Outcome:    resultat,
+		Encoding:   encodage,
+	})
+}
+
+// operation ajoute une modification d'un partage a la session (size : octets recus par Put).
+func (r *sessionRecord) operation(op string, share string, file string, target string, size int64, resultat string) {
+	r.Operations = append(r.Operations, operationRecord{
+		Operation: op,
+		Share:     share,
+		File:      file,
+		Target:    target,
+		Size:      size,
+		Outcome:   resultat,
+		Time:      time.Now(),
+	})
+}
+
+// accessLog ecrit une ligne JSON par session dans un fichier, avec rotation
+// selon la taille et l'age du fichier. Un accessLog nil n'ecrit rien.
+type accessLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	size    int64
+	ouvert  time.Time
+}
+
+// ouvrirAccessLog ouvre (ou cree) le journal d'acces. maxSize et maxAge
+// declenchent la rotation (0 = pas de rotation selon ce critere).
+func ouvrirAccessLog(path string, maxSize int64, maxAge time.Duration) (*accessLog, error) {
+	l := &accessLog{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := l.ouvrir(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *accessLog) ouvrir() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	l.ouvert = time.Now()
+	return nil
+}
+
+// rotation renomme le fichier courant avec un horodatage et en ouvre un nouveau.
+func (l *accessLog) rotation() error {
+	if err := l.file.Close(); err != nil {
+		l.file = nil
+		return err
+	}
+	base := l.path + "." + time.Now().Format("20060102-150405")
+	nom := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(nom); os.IsNotExist(err) {
+			break
+		}
+		nom = fmt.Sprintf("%s.%d", base, i)
+	}
+	if err := os.Rename(l.path, nom); err != nil {
+		// On continue dans le fichier courant
+		if errOuv := l.ouvrir(); errOuv != nil {
+			l.file = nil
+		}
+		return err
+	}
+	if err := l.ouvrir(); err != nil {
+		l.file = nil
+		return err
+	}
+	return nil
+}
+
+// ecrire ajoute l'enregistrement d'une session au journal.
+func (l *accessLog) ecrire(r *sessionRecord) error {
+	if l == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	if l.size > 0 && ((l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize) ||
+		(l.maxAge > 0 && time.Since(l.ouvert) > l.maxAge)) {
+		if err := l.rotation(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Close ferme le journal ; les ecritures suivantes echouent.
+func (l *accessLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	// Ecrit le journal sur le disque avant de le fermer
+	err := l.file.Sync()
+	if errClose := l.file.Close(); err == nil {
+		err = errClose
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fenetre sur laquelle est compte le nombre de connexions par adresse
+const fenetreTentatives = time.Minute
+
+// Par defaut, le port de controle n'accepte que les connexions locales
+var controleParDefaut = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// listeAcces contient les reseaux autorises et interdits pour un port.
+// Une adresse interdite est toujours refusee ; si la liste des reseaux
+// autorises n'est pas vide, une adresse qui n'en fait pas partie est refusee.
+type listeAcces struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func (l listeAcces) autorise(ip netip.Addr) bool {
+	for _, p := range l.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, p := range l.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reglesAcces est le contenu du fichier de regles d'acces.
+//
+// Format, une regle par ligne (les lignes vides et commencant par '#' sont ignorees) :
+//
+//	data allow|deny <cidr>       reseaux du port principal
+//	control allow|deny <cidr>    reseaux du port de controle (defaut : loopback)
+//	limit conn <n>               connexions simultanees par adresse
+//	limit rate <n>               connexions par minute et par adresse
+//	limit ban <duree>            duree du bannissement en cas de depassement de rate
+type reglesAcces struct {
+	data          listeAcces
+	control       listeAcces
+	maxConnexions int
+	maxTentatives int
+	dureeBan      time.Duration
+}
+
+func reglesParDefaut() *reglesAcces {
+	return &reglesAcces{
+		control:  listeAcces{allow: controleParDefaut},
+		dureeBan: 5 * time.Minute,
+	}
+}
+
+// chargerRegles lit le fichier de regles. Un chemin vide donne les regles par defaut.
+func chargerRegles(path string) (*reglesAcces, error) {
+	regles := reglesParDefaut()
+	if path == "" {
+		return regles, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	controlAllow := false
+	scanner := bufio.NewScanner(f)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected 3 fields, got %d", path, num, len(fields))
+		}
+
+		switch fields[0] {
+		case "data", "control":
+			prefix, err := parsePrefix(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, num, err)
+			}
+			liste := &regles.data
+			if fields[0] == "control" {
+				liste = &regles.control
+				// Les regles du fichier remplacent la valeur par defaut
+				if fields[1] == "allow" && !controlAllow {
+					liste.allow = nil
+					controlAllow = true
+				}
+			}
+			switch fields[1] {
+			case "allow":
+				liste.allow = append(liste.allow, prefix)
+			case "deny":
+				liste.deny = append(liste.deny, prefix)
+			default:
+				return nil, fmt.Errorf("%s:%d: expected allow or deny, got %q", path, num, fields[1])
+			}
+
+		case "limit":
+			if err := regles.parseLimite(fields[1], fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, num, err)
+			}
+
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown rule %q", path, num, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return regles, nil
+}
+
+func (r *reglesAcces) parseLimite(nom, valeur string) error {
+	switch nom {
+	case "conn", "rate":
+		n, err := strconv.Atoi(valeur)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid limit %s %q: expected a non-negative integer", nom, valeur)
+		}
+		if nom == "conn" {
+			r.maxConnexions = n
+		} else {
+			r.maxTentatives = n
+		}
+	case "ban":
+		d, err := time.ParseDuration(valeur)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid limit ban %q: expected a duration such as 5m", valeur)
+		}
+		r.dureeBan = d
+	default:
+		return fmt.Errorf("unknown limit %q", nom)
+	}
+	return nil
+}
+
+// parsePrefix accepte un reseau CIDR ou une adresse seule.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// Suivi des connexions d'une adresse
+type etatIP struct {
+	actives    int
+	tentatives []time.Time
+	banJusqua  time.Time
+}
+
+// filtreIP applique les regles d'acces aux connexions entrantes.
+// Il est partage par les deux boucles d'acceptation et les goroutines clients.
+type filtreIP struct {
+	mu     sync.Mutex
+	clock  clock
+	regles *reglesAcces
+	parIP  map[netip.Addr]*etatIP
+}
+
+func newFiltreIP(regles *reglesAcces, c clock) *filtreIP {
+	return &filtreIP{clock: c, regles: regles, parIP: make(map[netip.Addr]*etatIP)}
+}
+
+// remplacer installe de nouvelles regles ; les bannissements en cours sont conserves.
+func (f *filtreIP) remplacer(regles *reglesAcces) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.regles = regles
+}
+
+// adresseIP extrait l'adresse IP d'une adresse reseau.
+func adresseIP(addr net.Addr) netip.Addr {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// autoriserControle indique si une connexion au port de controle est acceptee.
+func (f *filtreIP) autoriserControle(ip netip.Addr) (bool, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.regles.control.autorise(ip) {
+		return false, "address not allowed"
+	}
+	return true, ""
+}
+
+// entrer indique si une connexion au port principal est acceptee, et si oui
+// la compte parmi les connexions actives de l'adresse (voir sortir).
+// En cas de refus, la raison est retournee.
+func (f *filtreIP) entrer(ip netip.Addr) (bool, string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock.Now()
+	etat := f.parIP[ip]
+	if etat == nil {
+		etat = &etatIP{}
+		f.parIP[ip] = etat
+	}
+	defer f.nettoyer(ip, etat, now)
+
+	if now.Before(etat.banJusqua) {
+		return false, "address banned until " + etat.banJusqua.Format(time.TimeOnly)
+	}
+	if !f.regles.data.autorise(ip) {
+		return false, "address not allowed"
+	}
+
+	// Connexions recentes, dans la fenetre glissante
+	recentes := etat.tentatives[:0]
+	for _, t := range etat.tentatives {
+		if now.Sub(t) < fenetreTentatives {
+			recentes = append(recentes, t)
+		}
+	}
+	etat.tentatives = append(recentes, now)
+	if f.regles.maxTentatives > 0 && len(etat.tentatives) > f.regles.maxTentatives {
+		etat.banJusqua = now.Add(f.regles.dureeBan)
+		etat.tentatives = nil
+		return false, "too many connections per minute, banned for " + f.regles.dureeBan.String()
+	}
+
+	if f.regles.maxConnexions > 0 && etat.actives >= f.regles.maxConnexions {
+		return false, "too many concurrent connections"
+	}
+
+	etat.actives++
+	return true, ""
+}
+
+// sortir signale la fin d'une connexion acceptee par entrer.
+func (f *filtreIP) sortir(ip netip.Addr) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if etat := f.parIP[ip]; etat != nil {
+		etat.actives--
+		f.nettoyer(ip, etat, f.clock.Now())
+	}
+}
+
+// nettoyer oublie une adresse qui n'a plus rien a suivre.
+func (f *filtreIP) nettoyer(ip netip.Addr, etat *etatIP, now time.Time) {
+	if etat.actives > 0 || now.Before(etat.banJusqua) {
+		return
+	}
+	for _, t := range etat.tentatives {
+		if now.Sub(t) < fenetreTentatives {
+			return
+		}
+	}
+	delete(f.parIP, ip)
+}
@@ -0,0 +1,128 @@
+package server
+
+// Gestion du nombre maximal de clients simultanes : au-dela de la limite,
+// les nouvelles connexions attendent dans une file bornee ou sont refusees.
+// L'etat est detenu par une goroutine unique (voir gererAdmission).
+
+// Client en attente d'une place
+type attente struct {
+	admis    chan struct{} // ferme quand le client obtient une place
+	position chan int      // derniere position connue dans la file
+}
+
+type entrerRequest struct {
+	response chan entrerResponse
+}
+
+type entrerResponse struct {
+	admis   bool     // place obtenue immediatement
+	attente *attente // nil si la file est pleine (client refuse)
+}
+
+type sortirRequest struct{}
+
+// Un client en attente abandonne (deconnexion, arret du serveur).
+// La reponse indique si une place lui avait deja ete attribuee.
+type abandonRequest struct {
+	attente  *attente
+	response chan bool
+}
+
+type admissionInfoRequest struct {
+	response chan admissionInfo
+}
+
+type admissionInfo struct {
+	limite, actifs, enAttente, tailleFile int
+}
+
+type limiteRequest struct {
+	limite   int
+	response chan bool
+}
+
+// Les clients deja en attente au-dela de la nouvelle taille gardent leur place
+type tailleFileRequest struct {
+	tailleFile int
+	response   chan bool
+}
+
+// gererAdmission traite les demandes d'entree et de sortie des clients.
+// limite = 0 signifie pas de limite ; tailleFile = 0 signifie que les clients
+// en surnombre sont refuses immediatement.
+func gererAdmission(requests chan interface{}, limite int, tailleFile int) {
+	actifs := 0
+	var file []*attente
+
+	// Admet les clients en attente tant qu'il reste des places,
+	// puis informe les suivants de leur nouvelle position
+	admettre := func() {
+		for len(file) > 0 && (limite == 0 || actifs < limite) {
+			close(file[0].admis)
+			file = file[1:]
+			actifs++
+		}
+		for i, a := range file {
+			notifierPosition(a, i+1)
+		}
+	}
+
+	for req := range requests {
+		switch r := req.(type) {
+		case entrerRequest:
+			if limite == 0 || actifs < limite {
+				actifs++
+				r.response <- entrerResponse{admis: true}
+			} else if len(file) < tailleFile {
+				a := &attente{admis: make(chan struct{}), position: make(chan int, 1)}
+				file = append(file, a)
+				notifierPosition(a, len(file))
+				r.response <- entrerResponse{attente: a}
+			} else {
+				r.response <- entrerResponse{}
+			}
+
+		case sortirRequest:
+			actifs--
+			admettre()
+
+		case abandonRequest:
+			dejaAdmis := true
+			for i, a := range file {
+				if a == r.attente {
+					file = append(file[:i], file[i+1:]...)
+					dejaAdmis = false
+					break
+				}
+			}
+			if !dejaAdmis {
+				for i, a := range file {
+					notifierPosition(a, i+1)
+				}
+			}
+			r.response <- dejaAdmis
+
+		case admissionInfoRequest:
+			r.response <- admissionInfo{limite: limite, actifs: actifs, enAttente: len(file), tailleFile: tailleFile}
+
+		case limiteRequest:
+			limite = r.limite
+			admettre()
+			r.response <- true
+
+		case tailleFileRequest:
+			tailleFile = r.tailleFile
+			r.response <- true
+		}
+	}
+}
+
+// notifierPosition remplace la position en attente de lecture par la nouvelle,
+// sans jamais bloquer le gestionnaire.
+func notifierPosition(a *attente, pos int) {
+	select {
+	case <-a.position:
+	default:
+	}
+	a.position <- pos
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stockageArchive sert les fichiers d'une archive (zip, tar ou tar.gz) en lecture
+// seule, comme si elle etait extraite. Les fichiers des sous-dossiers sont
+// servis sous leur chemin complet ("dossier/fichier").
+type stockageArchive struct {
+	membres map[string]membreArchive
+	infos   []fs.FileInfo
+
+	// Fichier de l'archive, ferme une fois l'archive remplacee dans le cache
+	// et tous ses membres ouverts refermes
+	mu       sync.Mutex
+	fichier  *os.File
+	lecteurs int
+	remplace bool
+	ferme    bool
+}
+
+type membreArchive struct {
+	info   fs.FileInfo
+	ouvrir func() (io.ReadSeekCloser, error)
+}
+
+func (s *stockageArchive) ajouter(nom string, taille int64, modif time.Time, ouvrir func() (io.ReadSeekCloser, error)) {
+	// Noms normalises : pas de "./" initial ni de chemin hors de l'archive
+	nom = strings.TrimPrefix(path.Clean("/"+nom), "/")
+	if !fs.ValidPath(nom) || nom == "." {
+		return
+	}
+	info := infoFichier{nom: nom, taille: taille, modif: modif}
+	if _, existe := s.membres[nom]; !existe {
+		s.infos = append(s.infos, info)
+	}
+	s.membres[nom] = membreArchive{info: info, ouvrir: ouvrir}
+}
+
+func (s *stockageArchive) lister() ([]fs.FileInfo, error) {
+	return s.infos, nil
+}
+
+func (s *stockageArchive) stat(name string) (fs.FileInfo, error) {
+	m, ok := s.membres[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return m.info, nil
+}
+
+func (s *stockageArchive) ouvrir(name string) (io.ReadSeekCloser, error) {
+	m, ok := s.membres[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	s.mu.Lock()
+	if s.ferme {
+		// Archive remplacee entre-temps : le client peut refaire sa demande
+		s.mu.Unlock()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrClosed}
+	}
+	s.lecteurs++
+	s.mu.Unlock()
+
+	r, err := m.ouvrir()
+	if err != nil {
+		s.liberer()
+		return nil, err
+	}
+	return &lecteurArchive{ReadSeekCloser: r, archive: s}, nil
+}
+
+// liberer compte un membre referme, et ferme l'archive remplacee quand
+// c'etait le dernier.
+func (s *stockageArchive) liberer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lecteurs--
+	s.fermerSiInutilisee()
+}
+
+// remplacer signale que l'archive n'est plus dans le cache : elle est
+// fermee des que ses membres ouverts sont refermes.
+func (s *stockageArchive) remplacer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remplace = true
+	s.fermerSiInutilisee()
+}
+
+func (s *stockageArchive) fermerSiInutilisee() {
+	if s.remplace && s.lecteurs == 0 && !s.ferme {
+		s.ferme = true
+		if s.fichier != nil {
+			s.fichier.Close()
+		}
+	}
+}
+
+// lecteurArchive est un membre ouvert ; Close le compte comme referme.
+type lecteurArchive struct {
+	io.ReadSeekCloser
+	archive *stockageArchive
+	once    sync.Once
+}
+
+func (l *lecteurArchive) Close() error {
+	err := l.ReadSeekCloser.Close()
+	l.once.Do(l.archive.liberer)
+	return err
+}
+
+// indexerZip lit le repertoire central d'une archive zip.
+// Le fichier f reste ouvert tant que le stockage est utilise.
+func indexerZip(f *os.File, taille int64) (*stockageArchive, error) {
+	zr, err := zip.NewReader(f, taille)
+	if err != nil {
+		return nil, err
+	}
+	s := &stockageArchive{membres: make(map[string]membreArchive)}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		zf := zf
+		s.ajouter(zf.Name, int64(zf.UncompressedSize64), zf.Modified, func() (io.ReadSeekCloser, error) {
+			// Membre non compresse : lecture directe dans l'archive
+			if zf.Method == zip.Store {
+				debut, err := zf.DataOffset()
+				if err != nil {
+					return nil, err
+				}
+				return lecteurSansFermeture{io.NewSectionReader(f, debut, int64(zf.UncompressedSize64))}, nil
+			}
+			return &lecteurSequentiel{ouvrir: zf.Open, taille: int64(zf.UncompressedSize64)}, nil
+		})
+	}
+	trierInfos(s.infos)
+	return s, nil
+}
+
+// indexerTar parcourt les en-tetes d'une archive tar et note la position
+// du contenu de chaque fichier. Le fichier f reste ouvert tant que le
+// stockage est utilise.
+func indexerTar(f *os.File) (*stockageArchive, error) {
+	return indexerEntetesTar(f, func(debut, taille int64) func() (io.ReadSeekCloser, error) {
+		return func() (io.ReadSeekCloser, error) {
+			return lecteurSansFermeture{io.NewSectionReader(f, debut, taille)}, nil
+		}
+	})
+}
+
+// indexerTarGz indexe une archive tar compressee avec gzip. Le contenu ne peut
+// etre lu qu'en decompressant depuis le debut : ouvrir un fichier decompresse
+// (et ignore) tout ce qui le precede dans l'archive.
+func indexerTarGz(f *os.File, taille int64) (*stockageArchive, error) {
+	gz, err := gzip.NewReader(io.NewSectionReader(f, 0, taille))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return indexerEntetesTar(gz, func(debut, tailleMembre int64) func() (io.ReadSeekCloser, error) {
+		ouvrirMembre := func() (io.ReadCloser, error) {
+			gz, err := gzip.NewReader(io.NewSectionReader(f, 0, taille))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(io.Discard, gz, debut); err != nil {
+				gz.Close()
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.LimitReader(gz, tailleMembre), gz}, nil
+		}
+		return func() (io.ReadSeekCloser, error) {
+			return &lecteurSequentiel{ouvrir: ouvrirMembre, taille: tailleMembre}, nil
+		}
+	})
+}
+
+// indexerEntetesTar lit les en-tetes d'un flux tar ; ouvrirMembre construit
+// l'ouverture d'un fichier a partir de la position et de la taille de son contenu.
+func indexerEntetesTar(r io.Reader, ouvrirMembre func(debut, taille int64) func() (io.ReadSeekCloser, error)) (*stockageArchive, error) {
+	lecteur := &lecteurPosition{r: r}
+	tr := tar.NewReader(lecteur)
+	s := &stockageArchive{membres: make(map[string]membreArchive)}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Apres Next, le lecteur est au debut du contenu du fichier
+		s.ajouter(hdr.Name, hdr.Size, hdr.ModTime, ouvrirMembre(lecteur.pos, hdr.Size))
+	}
+	trierInfos(s.infos)
+	return s, nil
+}
+
+// lecteurPosition suit la position dans le flux pendant l'indexation.
+// Si le flux le permet, Seek evite a tar de lire le contenu des fichiers.
+type lecteurPosition struct {
+	r   io.Reader
+	pos int64
+}
+
+func (l *lecteurPosition) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.pos += int64(n)
+	return n, err
+}
+
+func (l *lecteurPosition) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := l.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("stream is not seekable")
+	}
+	pos, err := seeker.Seek(offset, whence)
+	if err == nil {
+		l.pos = pos
+	}
+	return pos, err
+}
+
+// lecteurSequentiel rend deplacable un contenu qui ne se lit que dans l'ordre
+// (membre compresse) : reculer rouvre le contenu, avancer lit et ignore les octets.
+type lecteurSequentiel struct {
+	ouvrir func() (io.ReadCloser, error)
+	taille int64
+	rc     io.ReadCloser
+	// Position dans rc, et position demandee par Seek
+	lu, pos int64
+}
+
+func (l *lecteurSequentiel) Read(p []byte) (int, error) {
+	if l.rc == nil || l.pos < l.lu {
+		if l.rc != nil {
+			l.rc.Close()
+		}
+		rc, err := l.ouvrir()
+		if err != nil {
+			return 0, err
+		}
+		l.rc, l.lu = rc, 0
+	}
+	if l.pos > l.lu {
+		n, err := io.CopyN(io.Discard, l.rc, l.pos-l.lu)
+		l.lu += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.rc.Read(p)
+	l.lu += int64(n)
+	l.pos = l.lu
+	return n, err
+}
+
+func (l *lecteurSequentiel) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += l.pos
+	case io.SeekEnd:
+		offset += l.taille
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	l.pos = offset
+	return offset, nil
+}
+
+func (l *lecteurSequentiel) Close() error {
+	if l.rc == nil {
+		return nil
+	}
+	return l.rc.Close()
+}
+
+// cacheArchives garde les archives ouvertes et indexees, partagees par toutes
+// les sessions. Une archive modifiee sur le disque est indexee a nouveau ;
+// l'ancienne version est fermee une fois ses membres ouverts refermes.
+type cacheArchives struct {
+	mu       sync.Mutex
+	archives map[string]archiveOuverte
+}
+
+type archiveOuverte struct {
+	stockage *stockageArchive
+	modif    time.Time
+	taille   int64
+}
+
+func newCacheArchives() *cacheArchives {
+	return &cacheArchives{archives: make(map[string]archiveOuverte)}
+}
+
+// ouvrir retourne l'archive chemin ; si genre est vide, le format est
+// reconnu au contenu de l'archive.
+func (c *cacheArchives) ouvrir(genre, chemin string) (stockage, error) {
+	info, err := os.Stat(chemin)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cle := genre + ":" + chemin
+	if a, ok := c.archives[cle]; ok && a.modif.Equal(info.ModTime()) && a.taille == info.Size() {
+		return a.stockage, nil
+	}
+
+	format := genre
+	if format == "" {
+		if format, err = detecterArchive(chemin); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(chemin)
+	if err != nil {
+		return nil, err
+	}
+	var s *stockageArchive
+	switch format {
+	case stockageZip:
+		s, err = indexerZip(f, info.Size())
+	case stockageTarGz:
+		s, err = indexerTarGz(f, info.Size())
+	default:
+		s, err = indexerTar(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.fichier = f
+	if a, ok := c.archives[cle]; ok {
+		a.stockage.remplacer()
+	}
+	c.archives[cle] = archiveOuverte{stockage: s, modif: info.ModTime(), taille: info.Size()}
+	return s, nil
+}
+
+// fermer ferme toutes les archives, a l'arret du serveur (apres la fin des
+// sessions : les membres encore ouverts gardent leur archive ouverte).
+func (c *cacheArchives) fermer() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cle, a := range c.archives {
+		a.stockage.remplacer()
+		delete(c.archives, cle)
+	}
+}
+
+// detecterArchive reconnait le format d'une archive a son contenu : zip, tar
+// ou tar compresse avec gzip.
+func detecterArchive(chemin string) (string, error) {
+	f, err := os.Open(chemin)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	entete := make([]byte, 512)
+	n, _ := io.ReadFull(f, entete)
+	entete = entete[:n]
+	switch {
+	case bytes.HasPrefix(entete, []byte("PK\x03\x04")) || bytes.HasPrefix(entete, []byte("PK\x05\x06")):
+		return stockageZip, nil
+	case estEnteteTar(entete):
+		return stockageTar, nil
+	case bytes.HasPrefix(entete, []byte{0x1f, 0x8b}):
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		entete = make([]byte, 512)
+		n, _ := io.ReadFull(gz, entete)
+		if estEnteteTar(entete[:n]) {
+			return stockageTarGz, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a directory or a zip/tar archive", chemin)
+}
+
+// estEnteteTar reconnait le premier bloc d'une archive tar (format ustar ou GNU).
+func estEnteteTar(bloc []byte) bool {
+	return len(bloc) >= 512 && bytes.HasPrefix(bloc[257:], []byte("ustar"))
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ecrireZip cree l'archive chemin avec les fichiers donnes, datee de modif.
+// Une archive existante est remplacee par renommage, comme lors d'une mise a jour.
+func ecrireZip(t *testing.T, chemin string, fichiers map[string]string, modif time.Time) {
+	t.Helper()
+	f, err := os.Create(chemin + ".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for nom, contenu := range fichiers {
+		w, err := zw.Create(nom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, contenu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f.Name(), modif, modif); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(f.Name(), chemin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheArchivesRemplacement(t *testing.T) {
+	chemin := filepath.Join(t.TempDir(), "partage.zip")
+	debut := time.Now().Add(-time.Hour)
+	ecrireZip(t, chemin, map[string]string{"a.txt": "version 1"}, debut)
+
+	c := newCacheArchives()
+	defer c.fermer()
+	stock, err := c.ouvrir(stockageZip, chemin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ancienne := stock.(*stockageArchive)
+	lecteur, err := ancienne.ouvrir("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Archive modifiee : le cache l'indexe a nouveau, l'ancienne reste
+	// ouverte tant qu'un membre est en cours de lecture
+	ecrireZip(t, chemin, map[string]string{"a.txt": "version 2", "b.txt": "b"}, debut.Add(time.Minute))
+	stock, err = c.ouvrir(stockageZip, chemin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stock == ancienne {
+		t.Fatal("archive modifiee non indexee a nouveau")
+	}
+	data, err := io.ReadAll(lecteur)
+	if err != nil || string(data) != "version 1" {
+		t.Errorf("lecture de l'ancienne version = %q, %v", data, err)
+	}
+	if ancienne.ferme {
+		t.Error("ancienne archive fermee pendant une lecture")
+	}
+
+	lecteur.Close()
+	if !ancienne.ferme {
+		t.Error("ancienne archive non fermee apres la derniere lecture")
+	}
+	if _, err := ancienne.ouvrir("a.txt"); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("ouverture dans l'archive fermee : erreur %v, attendu fs.ErrClosed", err)
+	}
+
+	// L'archive courante est fermee a l'arret
+	courante := stock.(*stockageArchive)
+	c.fermer()
+	if !courante.ferme {
+		t.Error("archive non fermee a l'arret")
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// Extensions de fichiers deja compresses : les recompresser ne fait
+// que consommer du CPU
+var extensionsCompressees = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".bz2": true, ".xz": true,
+	".zst": true, ".7z": true, ".rar": true, ".jar": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".mp3": true, ".mp4": true, ".mkv": true, ".avi": true, ".ogg": true,
+	".odt": true, ".ods": true, ".docx": true, ".xlsx": true, ".pdf": true,
+}
+
+// Ordre de preference des encodages cote serveur
+var encodagesSupportes = []string{proto.EncodingGzip, proto.EncodingDeflate}
+
+// choisirEncodage decide de compresser ou non un fichier en fonction des
+// encodages annonces par le client, du type et de la taille du fichier.
+// Retourne "" si le fichier doit etre envoye sans compression.
+func choisirEncodage(config Config, filename string, size int64, acceptes []string) string {
+	if !config.Compress || len(acceptes) == 0 {
+		return ""
+	}
+	if size < config.CompressMinSize {
+		return ""
+	}
+	if extensionsCompressees[strings.ToLower(filepath.Ext(filename))] {
+		return ""
+	}
+
+	for _, enc := range encodagesSupportes {
+		for _, a := range acceptes {
+			if a == enc {
+				return enc
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
+)
+
+// fichierConfig est le format JSON du fichier de configuration.
+// Les cles absentes du fichier gardent la valeur deja presente dans Config.
+// Il n'y a pas de section TLS ni utilisateurs : le serveur ne gere ni l'un ni
+// l'autre, et une cle inconnue est refusee.
+type fichierConfig struct {
+	Listen struct {
+		Port        string `json:"port"`
+		ControlPort string `json:"control_port"`
+		Metrics     string `json:"metrics"`
+	} `json:"listen"`
+	Dir      string   `json:"dir"`
+	Shares   []Share  `json:"shares"`
+	Hide     []string `json:"hide"`
+	Transfer struct {
+		Chunked         bool  `json:"chunked"`
+		Compress        bool  `json:"compress"`
+		CompressMinSize int64 `json:"compress_min_size"`
+	} `json:"transfer"`
+	Limits struct {
+		Rate        int64  `json:"rate"`
+		ClientRate  int64  `json:"client_rate"`
+		MaxClients  int    `json:"max_clients"`
+		Queue       int    `json:"queue"`
+		Acl         string `json:"acl"`
+		UserQuota   int64  `json:"user_quota"`
+		MaxFileSize int64  `json:"max_file_size"`
+	} `json:"limits"`
+	Timeouts struct {
+		Idle string `json:"idle"`
+	} `json:"timeouts"`
+	Cache struct {
+		ListingPoll string `json:"listing_poll"`
+		CheckFiles  bool   `json:"check_files"`
+	} `json:"cache"`
+	AccessLog struct {
+		File    string `json:"file"`
+		MaxSize int64  `json:"max_size"`
+		MaxAge  string `json:"max_age"`
+	} `json:"access_log"`
+	Log struct {
+		Format     string `json:"format"`
+		File       string `json:"file"`
+		Level      string `json:"level"`
+		Subsystems string `json:"subsystems"`
+	} `json:"log"`
+	Dashboard bool `json:"dashboard"`
+}
+
+// LireConfig applique le fichier de configuration path sur config.
+// Les erreurs indiquent le fichier, et la ligne ou la cle en cause.
+func LireConfig(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var f fichierConfig
+	f.Listen.Port = config.Port
+	f.Listen.ControlPort = config.ControlPort
+	f.Listen.Metrics = config.MetricsAddr
+	f.Dir = config.Dir
+	f.Shares = config.Shares
+	f.Hide = config.Hide
+	f.Transfer.Chunked = config.Chunked
+	f.Transfer.Compress = config.Compress
+	f.Transfer.CompressMinSize = config.CompressMinSize
+	f.Limits.Rate = config.GlobalRate
+	f.Limits.ClientRate = config.ClientRate
+	f.Limits.MaxClients = config.MaxClients
+	f.Limits.Queue = config.QueueSize
+	f.Limits.Acl = config.AclFile
+	f.Limits.UserQuota = config.UserQuota
+	f.Limits.MaxFileSize = config.MaxFileSize
+	f.Timeouts.Idle = config.IdleTimeout.String()
+	f.Cache.ListingPoll = config.ListingPoll.String()
+	f.Cache.CheckFiles = config.ListingCheckFiles
+	f.AccessLog.File = config.AccessLog
+	f.AccessLog.MaxSize = config.AccessLogMaxSize
+	f.AccessLog.MaxAge = config.AccessLogMaxAge.String()
+	f.Log.Format = config.Log.Format
+	f.Log.File = config.Log.File
+	f.Log.Level = config.Log.Level.String()
+	f.Log.Subsystems = config.Log.Subsystems
+	f.Dashboard = config.Dashboard
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return fmt.Errorf("%s: %w", path, erreurJSON(data, dec, err))
+	}
+
+	idle, err := time.ParseDuration(f.Timeouts.Idle)
+	if err != nil {
+		return fmt.Errorf("%s: timeouts.idle: invalid duration %q", path, f.Timeouts.Idle)
+	}
+	listingPoll, err := time.ParseDuration(f.Cache.ListingPoll)
+	if err != nil {
+		return fmt.Errorf("%s: cache.listing_poll: invalid duration %q", path, f.Cache.ListingPoll)
+	}
+	maxAge, err := time.ParseDuration(f.AccessLog.MaxAge)
+	if err != nil {
+		return fmt.Errorf("%s: access_log.max_age: invalid duration %q", path, f.AccessLog.MaxAge)
+	}
+	level, err := logging.ParseLevel(f.Log.Level)
+	if err != nil {
+		return fmt.Errorf("%s: log.level: %w", path, err)
+	}
+
+	config.Port = f.Listen.Port
+	config.ControlPort = f.Listen.ControlPort
+	config.MetricsAddr = f.Listen.Metrics
+	config.Dir = f.Dir
+	config.Shares = f.Shares
+	config.Hide = f.Hide
+	config.Chunked = f.Transfer.Chunked
+	config.Compress = f.Transfer.Compress
+	config.CompressMinSize = f.Transfer.CompressMinSize
+	config.GlobalRate = f.Limits.Rate
+	config.ClientRate = f.Limits.ClientRate
+	config.MaxClients = f.Limits.MaxClients
+	config.QueueSize = f.Limits.Queue
+	config.AclFile = f.Limits.Acl
+	config.UserQuota = f.Limits.UserQuota
+	config.MaxFileSize = f.Limits.MaxFileSize
+	config.IdleTimeout = idle
+	config.ListingPoll = listingPoll
+	config.ListingCheckFiles = f.Cache.CheckFiles
+	config.AccessLog = f.AccessLog.File
+	config.AccessLogMaxSize = f.AccessLog.MaxSize
+	config.AccessLogMaxAge = maxAge
+	config.Log.Format = f.Log.Format
+	config.Log.File = f.Log.File
+	config.Log.Level = level
+	config.Log.Subsystems = f.Log.Subsystems
+	config.Dashboard = f.Dashboard
+	return nil
+}
+
+// erreurJSON precise la position (ligne:colonne) ou la cle d'une erreur de decodage.
+func erreurJSON(data []byte, dec *json.Decoder, err error) error {
+	var syntaxe *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxe):
+		ligne, col := position(data, syntaxe.Offset)
+		return fmt.Errorf("line %d, column %d: %v", ligne, col, syntaxe)
+	case errors.As(err, &typeErr):
+		ligne, col := position(data, typeErr.Offset)
+		return fmt.Errorf("line %d, column %d: %s: expected %s, got %s", ligne, col, typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		// Cle inconnue : le decodeur est juste apres la cle fautive
+		ligne, col := position(data, dec.InputOffset())
+		return fmt.Errorf("line %d, column %d: %v", ligne, col, err)
+	}
+}
+
+func position(data []byte, offset int64) (ligne, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	avant := data[:offset]
+	ligne = bytes.Count(avant, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(avant, '\n')
+	return
+}
+
+// Valider verifie la configuration complete (fichier et options) et
+// retourne toutes les erreurs trouvees.
+func (c Config) Valider() error {
+	var erreurs []error
+	ajouter := func(format string, args ...any) {
+		erreurs = append(erreurs, fmt.Errorf(format, args...))
+	}
+
+	validerPort := func(nom, port string) {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 {
+			ajouter("%s: invalid port %q (expected 1-65535)", nom, port)
+		}
+	}
+	validerPort("listen.port", c.Port)
+	validerPort("listen.control_port", c.ControlPort)
+	if c.Port == c.ControlPort {
+		ajouter("listen.control_port: must differ from listen.port (%s)", c.Port)
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			ajouter("listen.metrics: invalid address %q: %v", c.MetricsAddr, err)
+		}
+	}
+
+	// Le dossier servi peut aussi etre une archive
+	if _, err := detecterStockage(c.Dir); err != nil {
+		ajouter("dir: %v", err)
+	}
+	validerPartages(c.Shares, ajouter)
+	for i, motif := range c.Hide {
+		if _, err := filepath.Match(motif, ""); err != nil {
+			ajouter("hide[%d]: invalid pattern %q", i, motif)
+		}
+	}
+
+	positif := func(nom string, v int64) {
+		if v < 0 {
+			ajouter("%s: must be 0 or more, got %d", nom, v)
+		}
+	}
+	positif("transfer.compress_min_size", c.CompressMinSize)
+	positif("limits.rate", c.GlobalRate)
+	positif("limits.client_rate", c.ClientRate)
+	positif("limits.max_clients", int64(c.MaxClients))
+	positif("limits.queue", int64(c.QueueSize))
+	positif("limits.user_quota", c.UserQuota)
+	positif("limits.max_file_size", c.MaxFileSize)
+	positif("access_log.max_size", c.AccessLogMaxSize)
+	if c.IdleTimeout < 0 {
+		ajouter("timeouts.idle: must be 0 or more, got %s", c.IdleTimeout)
+	}
+	if c.ListingPoll < 0 {
+		ajouter("cache.listing_poll: must be 0 or more, got %s", c.ListingPoll)
+	}
+	if c.AccessLogMaxAge < 0 {
+		ajouter("access_log.max_age: must be 0 or more, got %s", c.AccessLogMaxAge)
+	}
+
+	if _, err := chargerRegles(c.AclFile); err != nil {
+		ajouter("limits.acl: %v", err)
+	}
+
+	switch c.Log.Format {
+	case "", "text", "json":
+	default:
+		ajouter("log.format: unknown format %q (expected text or json)", c.Log.Format)
+	}
+	if _, err := logging.ParseSubsystems(c.Log.Subsystems); err != nil {
+		ajouter("log.subsystems: %v", err)
+	}
+
+	return errors.Join(erreurs...)
+}
+
+// correspondMotif indique si name correspond a l'un des motifs (filepath.Match).
+func correspondMotif(motifs []string, name string) bool {
+	for _, motif := range motifs {
+		if ok, _ := filepath.Match(motif, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Periode de rafraichissement et nombre d'evenements recents affiches
+const (
+	periodeTableau    = 500 * time.Millisecond
+	evenementsRecents = 10
+)
+
+// Activite courante d'un client, vue par le tableau de bord
+type activiteClient struct {
+	client   string
+	depuis   time.Time
+	commande string
+	fichier  string
+	octets   int64
+	total    int64
+}
+
+// tableauDeBord maintient une vue de l'activite du serveur a partir
+// des evenements du bus, et la redessine periodiquement.
+type tableauDeBord struct {
+	out       io.Writer
+	debut     time.Time
+	clients   map[string]*activiteClient
+	recents   []evenement
+	envoyes   int64 // octets envoyes, tous transferts confondus
+	precedent int64
+	debit     float64 // octets/s sur la derniere periode
+}
+
+// lancerTableauDeBord affiche l'etat du serveur sur out jusqu'a l'arret du serveur.
+func lancerTableauDeBord(bus *busEvenements, shutdown chan struct{}, out io.Writer) {
+	abonnement := bus.abonner()
+	t := &tableauDeBord{out: out, debut: time.Now(), clients: make(map[string]*activiteClient)}
+
+	ticker := time.NewTicker(periodeTableau)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-abonnement.evenements:
+			t.appliquer(ev)
+		case <-ticker.C:
+			t.debit = float64(t.envoyes-t.precedent) / periodeTableau.Seconds()
+			t.precedent = t.envoyes
+			t.afficher()
+		case <-shutdown:
+			t.afficher()
+			return
+		}
+	}
+}
+
+// appliquer met a jour la vue avec un evenement.
+func (t *tableauDeBord) appliquer(ev evenement) {
+	a := t.clients[ev.session]
+
+	switch ev.genre {
+	case evConnexion:
+		t.clients[ev.session] = &activiteClient{client: ev.client, depuis: ev.date}
+	case evDeconnexion:
+		delete(t.clients, ev.session)
+	case evCommande:
+		if a != nil {
+			a.commande, a.fichier, a.octets, a.total = ev.texte, "", 0, 0
+		}
+	case evInactif:
+		if a != nil {
+			a.commande, a.fichier, a.octets, a.total = "", "", 0, 0
+		}
+		return
+	case evProgression:
+		if a != nil {
+			t.envoyes += ev.octets - a.octets
+			a.fichier, a.octets, a.total = ev.fichier, ev.octets, ev.total
+		}
+		return
+	case evTransfert:
+		if a != nil {
+			t.envoyes += ev.octets - a.octets
+			a.octets = ev.octets
+		}
+	}
+
+	t.recents = append(t.recents, ev)
+	if len(t.recents) > evenementsRecents {
+		t.recents = t.recents[len(t.recents)-evenementsRecents:]
+	}
+}
+
+// afficher efface le terminal et redessine la vue.
+func (t *tableauDeBord) afficher() {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "Server up %s - %d client(s) connected - %s/s\n\n",
+		time.Since(t.debut).Truncate(time.Second), len(t.clients), formatOctets(int64(t.debit)))
+
+	sessions := make([]string, 0, len(t.clients))
+	for s := range t.clients {
+		sessions = append(sessions, s)
+	}
+	sort.Strings(sessions)
+
+	fmt.Fprintf(&b, "%-16s %-22s %-9s %s\n", "SESSION", "CLIENT", "SINCE", "ACTIVITY")
+	for _, s := range sessions {
+		a := t.clients[s]
+		activite := "idle"
+		if a.commande != "" {
+			activite = a.commande
+		}
+		if a.fichier != "" {
+			activite += " " + progression(a.octets, a.total)
+		}
+		fmt.Fprintf(&b, "%-16s %-22s %-9s %s\n", s, a.client, time.Since(a.depuis).Truncate(time.Second), activite)
+	}
+
+	b.WriteString("\nRecent events:\n")
+	for _, ev := range t.recents {
+		fmt.Fprintf(&b, "  %s %-16s %-10s %s %s\n", ev.date.Format(time.TimeOnly), ev.session, ev.genre, ev.client, ev.texte)
+	}
+
+	io.WriteString(t.out, b.String())
+}
+
+// progression formate l'avancement d'un transfert.
+func progression(octets, total int64) string {
+	if total <= 0 {
+		return formatOctets(octets)
+	}
+	return fmt.Sprintf("%s / %s (%d%%)", formatOctets(octets), formatOctets(total), octets*100/total)
+}
+
+// formatOctets formate une taille avec l'unite binaire adaptee.
+func formatOctets(n int64) string {
+	const unite = 1024
+	if n < unite {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unite), 0
+	for m := n / unite; m >= unite; m /= unite {
+		div *= unite
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+)
+
+// Nombre maximal d'empreintes gardees en cache
+const maxEmpreintes = 10000
+
+// cacheEmpreintes garde l'empreinte SHA-256 des fichiers, partagee par toutes
+// les sessions : un gros fichier n'est relu que s'il a change (taille ou date).
+type cacheEmpreintes struct {
+	mu         sync.Mutex
+	empreintes map[string]empreinteFichier
+}
+
+type empreinteFichier struct {
+	taille int64
+	modif  time.Time
+	sha256 string
+}
+
+func newCacheEmpreintes() *cacheEmpreintes {
+	return &cacheEmpreintes{empreintes: make(map[string]empreinteFichier)}
+}
+
+// calculer retourne la taille et l'empreinte du fichier cle, decrit par info,
+// en le lisant avec ouvrir s'il n'est pas en cache ou s'il a change.
+// Deux sessions peuvent calculer en meme temps l'empreinte d'un meme fichier.
+func (c *cacheEmpreintes) calculer(cle string, info fs.FileInfo, ouvrir func() (io.ReadSeekCloser, error)) (int64, string, error) {
+	c.mu.Lock()
+	e, ok := c.empreintes[cle]
+	c.mu.Unlock()
+	if ok && e.taille == info.Size() && e.modif.Equal(info.ModTime()) {
+		return e.taille, e.sha256, nil
+	}
+
+	file, err := ouvrir()
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	e = empreinteFichier{taille: n, modif: info.ModTime(), sha256: hex.EncodeToString(h.Sum(nil))}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Cache plein : une entree quelconque laisse sa place
+	if _, existe := c.empreintes[cle]; !existe && len(c.empreintes) >= maxEmpreintes {
+		for k := range c.empreintes {
+			delete(c.empreintes, k)
+			break
+		}
+	}
+	c.empreintes[cle] = e
+	return e.taille, e.sha256, nil
+}
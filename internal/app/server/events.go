@@ -0,0 +1,140 @@
+package server
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types d'evenements publies sur le bus
+const (
+	evConnexion   = "connect"    // client admis
+	evDeconnexion = "disconnect" // fin de session
+	evCommande    = "command"    // debut d'une commande (texte = ligne recue)
+	evInactif     = "idle"       // fin d'une commande
+	evProgression = "progress"   // octets envoyes pendant un Get
+	evTransfert   = "transfer"   // fin d'un Get (texte = resultat)
+	evFichier     = "file"       // fichier ajoute, supprime, modifie, cache ou revele
+	evInfo        = "info"       // autre evenement notable (texte)
+)
+
+// Intervalle minimal entre deux evenements de progression d'un transfert,
+// et taille des tranches envoyees par le noyau entre deux verifications
+const (
+	intervalleProgression = 200 * time.Millisecond
+	trancheZeroCopie      = 4 << 20
+)
+
+// evenement decrit ce qui se passe dans le serveur, pour les observateurs
+// (tableau de bord) qui ne doivent pas dependre des logs.
+type evenement struct {
+	date    time.Time
+	genre   string
+	session string
+	client  string
+	fichier string
+	octets  int64
+	total   int64 // taille attendue, -1 si inconnue
+	texte   string
+	// evFichier : changement (proto.Evenement*) et partage ou dossier concerne
+	action  string
+	partage string
+	dossier string
+}
+
+// busEvenements diffuse les evenements a tous les abonnes. La publication ne
+// bloque jamais : un abonne trop lent perd des evenements, et en est prevenu
+// par son canal pertes.
+type busEvenements struct {
+	mu      sync.Mutex
+	abonnes []*abonnement
+}
+
+// abonnement recoit les evenements publies sur le bus.
+type abonnement struct {
+	evenements chan evenement
+	// Recoit une valeur quand au moins un evenement a ete perdu (tampon plein)
+	pertes chan struct{}
+	// Genres d'evenements recus (tous si vide)
+	genres []string
+}
+
+// abonner retourne un abonnement recevant les evenements des genres donnes
+// (tous si aucun) publies desormais.
+func (b *busEvenements) abonner(genres ...string) *abonnement {
+	a := &abonnement{
+		evenements: make(chan evenement, 256),
+		pertes:     make(chan struct{}, 1),
+		genres:     genres,
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.abonnes = append(b.abonnes, a)
+	return a
+}
+
+// desabonner arrete l'envoi des evenements a a.
+func (b *busEvenements) desabonner(a *abonnement) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, abonne := range b.abonnes {
+		if abonne == a {
+			b.abonnes = append(b.abonnes[:i], b.abonnes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *busEvenements) publier(ev evenement) {
+	if ev.date.IsZero() {
+		ev.date = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, a := range b.abonnes {
+		if len(a.genres) > 0 && !slices.Contains(a.genres, ev.genre) {
+			continue
+		}
+		select {
+		case a.evenements <- ev:
+		default:
+			select {
+			case a.pertes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// changementFichier construit l'evenement d'un changement de fichier.
+func changementFichier(action, fichier string) evenement {
+	return evenement{genre: evFichier, action: action, fichier: fichier, texte: action + " " + fichier}
+}
+
+// suiviProgression appelle progres au plus toutes les intervalleProgression.
+type suiviProgression struct {
+	dernier time.Time
+	progres func(int64)
+}
+
+func (s *suiviProgression) signaler(n int64) {
+	if now := time.Now(); now.Sub(s.dernier) >= intervalleProgression {
+		s.dernier = now
+		s.progres(n)
+	}
+}
+
+// lecteurProgression compte les octets lus et signale l'avancement.
+type lecteurProgression struct {
+	r     io.Reader
+	n     int64
+	suivi *suiviProgression
+}
+
+func (lp *lecteurProgression) Read(p []byte) (int, error) {
+	n, err := lp.r.Read(p)
+	lp.n += int64(n)
+	lp.suivi.signaler(lp.n)
+	return n, err
+}
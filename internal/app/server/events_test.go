@@ -0,0 +1,70 @@
+package server
+
+import (
+	"testing"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+func TestBusAbonnementFiltre(t *testing.T) {
+	bus := &busEvenements{}
+	fichiers := bus.abonner(evFichier)
+	tous := bus.abonner()
+	defer bus.desabonner(fichiers)
+	defer bus.desabonner(tous)
+
+	// Les evenements des autres sessions ne remplissent pas le tampon de Watch
+	for i := 0; i < 2*cap(fichiers.evenements); i++ {
+		bus.publier(evenement{genre: evProgression, octets: int64(i)})
+	}
+	bus.publier(changementFichier(proto.EvenementAjout, "a.txt"))
+
+	select {
+	case ev := <-fichiers.evenements:
+		if ev.genre != evFichier || ev.fichier != "a.txt" {
+			t.Errorf("evenement recu %+v, attendu l'ajout de a.txt", ev)
+		}
+	default:
+		t.Fatal("changement de fichier perdu")
+	}
+	select {
+	case <-fichiers.pertes:
+		t.Error("perte signalee sans evenement perdu")
+	default:
+	}
+
+	// L'abonne sans filtre, lui, a perdu des evenements
+	select {
+	case <-tous.pertes:
+	default:
+		t.Error("perte non signalee")
+	}
+}
+
+func TestBusPerteSignalee(t *testing.T) {
+	bus := &busEvenements{}
+	a := bus.abonner(evFichier)
+	defer bus.desabonner(a)
+
+	for i := 0; i <= cap(a.evenements); i++ {
+		bus.publier(changementFichier(proto.EvenementAjout, "a.txt"))
+	}
+	if len(a.evenements) != cap(a.evenements) {
+		t.Errorf("%d evenements en attente, attendu %d", len(a.evenements), cap(a.evenements))
+	}
+	select {
+	case <-a.pertes:
+	default:
+		t.Fatal("perte non signalee")
+	}
+
+	// Apres desabonnement, plus rien n'est recu
+	bus.desabonner(a)
+	for len(a.evenements) > 0 {
+		<-a.evenements
+	}
+	bus.publier(changementFichier(proto.EvenementAjout, "b.txt"))
+	if len(a.evenements) != 0 {
+		t.Error("evenement recu apres desabonnement")
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// Intervalle de verification d'un fichier suivi (Follow)
+const intervalleSuivi = 250 * time.Millisecond
+
+// fichierSuivi lit un fichier qui grandit, en suivant sa troncature et sa
+// rotation (fichier renomme puis recree sous le meme nom).
+type fichierSuivi struct {
+	chemin string
+	file   *os.File
+	// Position de lecture dans file
+	pos int64
+	// Fichier remplace par une rotation, lu jusqu'au bout avant file
+	ancien *os.File
+}
+
+func ouvrirSuivi(chemin string) (*fichierSuivi, error) {
+	file, err := os.Open(chemin)
+	if err != nil {
+		return nil, err
+	}
+	return &fichierSuivi{chemin: chemin, file: file}, nil
+}
+
+// Read lit la suite du fichier ; io.EOF signifie seulement qu'il n'y a
+// rien de nouveau pour l'instant.
+func (s *fichierSuivi) Read(p []byte) (int, error) {
+	if s.ancien != nil {
+		// Lignes ecrites dans l'ancien fichier juste avant la rotation
+		if n, _ := s.ancien.Read(p); n > 0 {
+			return n, nil
+		}
+		s.ancien.Close()
+		s.ancien = nil
+	}
+	n, err := s.file.Read(p)
+	s.pos += int64(n)
+	return n, err
+}
+
+// placer positionne la lecture sur les n dernieres lignes (n < 0 : tout le fichier).
+func (s *fichierSuivi) placer(n int) error {
+	if n < 0 {
+		return nil
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	debut, err := debutDernieresLignes(s.file, info.Size(), n)
+	if err != nil {
+		return err
+	}
+	s.pos, err = s.file.Seek(debut, io.SeekStart)
+	return err
+}
+
+// verifier detecte une rotation (le nom designe un autre fichier, relu depuis
+// le debut une fois la fin de l'ancien lue) ou une troncature (fichier plus
+// court que la position de lecture, relu depuis le debut). Un fichier absent
+// (rotation en cours) est ignore.
+func (s *fichierSuivi) verifier(log *slog.Logger) {
+	info, err := os.Stat(s.chemin)
+	if err != nil {
+		return
+	}
+	actuel, err := s.file.Stat()
+	if err != nil || !os.SameFile(info, actuel) {
+		file, err := os.Open(s.chemin)
+		if err != nil {
+			return
+		}
+		log.Info("Followed file rotated, reading the new file", "file", s.chemin)
+		if s.ancien != nil {
+			s.ancien.Close()
+		}
+		s.ancien = s.file
+		s.file, s.pos = file, 0
+		return
+	}
+	if info.Size() < s.pos {
+		log.Info("Followed file truncated, reading from the start", "file", s.chemin, "size", info.Size())
+		s.pos, _ = s.file.Seek(0, io.SeekStart)
+	}
+}
+
+func (s *fichierSuivi) Close() error {
+	if s.ancien != nil {
+		s.ancien.Close()
+	}
+	return s.file.Close()
+}
+
+// debutDernieresLignes retourne la position du debut des n dernieres lignes
+// de f (taille octets). Le saut de ligne final ne compte pas comme une ligne.
+func debutDernieresLignes(f io.ReaderAt, taille int64, n int) (int64, error) {
+	if n == 0 {
+		return taille, nil
+	}
+	fin := taille
+	// Ignore le saut de ligne qui termine la derniere ligne
+	if fin > 0 {
+		dernier := make([]byte, 1)
+		if _, err := f.ReadAt(dernier, fin-1); err != nil {
+			return 0, err
+		}
+		if dernier[0] == '\n' {
+			fin--
+		}
+	}
+
+	bloc := make([]byte, 4096)
+	vus := 0
+	for fin > 0 {
+		debut := fin - int64(len(bloc))
+		if debut < 0 {
+			debut = 0
+		}
+		b := bloc[:fin-debut]
+		if _, err := f.ReadAt(b, debut); err != nil {
+			return 0, err
+		}
+		for i := len(b) - 1; i >= 0; i-- {
+			if b[i] != '\n' {
+				continue
+			}
+			if vus++; vus == n {
+				return debut + int64(i) + 1, nil
+			}
+		}
+		fin = debut
+	}
+	return 0, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func ajouter(t *testing.T, chemin, texte string) {
+	t.Helper()
+	f, err := os.OpenFile(chemin, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(texte); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lireSuite(t *testing.T, s *fichierSuivi) string {
+	t.Helper()
+	data, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSuiviRotation(t *testing.T) {
+	chemin := filepath.Join(t.TempDir(), "app.log")
+	ajouter(t, chemin, "ligne 1\n")
+
+	s, err := ouvrirSuivi(chemin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := lireSuite(t, s); got != "ligne 1\n" {
+		t.Fatalf("lecture initiale = %q", got)
+	}
+
+	// Lignes ecrites juste avant la rotation, pas encore lues
+	ajouter(t, chemin, "ligne 2\n")
+	if err := os.Rename(chemin, chemin+".1"); err != nil {
+		t.Fatal(err)
+	}
+	ajouter(t, chemin, "nouveau 1\n")
+
+	s.verifier(slog.Default())
+	if got := lireSuite(t, s); got != "ligne 2\nnouveau 1\n" {
+		t.Errorf("lecture apres rotation = %q, attendu la fin de l'ancien fichier puis le nouveau", got)
+	}
+
+	ajouter(t, chemin, "nouveau 2\n")
+	s.verifier(slog.Default())
+	if got := lireSuite(t, s); got != "nouveau 2\n" {
+		t.Errorf("lecture suivante = %q", got)
+	}
+}
+
+func TestSuiviTroncature(t *testing.T) {
+	chemin := filepath.Join(t.TempDir(), "app.log")
+	ajouter(t, chemin, "ligne 1\nligne 2\n")
+
+	s, err := ouvrirSuivi(chemin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	lireSuite(t, s)
+
+	if err := os.WriteFile(chemin, []byte("court\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.verifier(slog.Default())
+	if got := lireSuite(t, s); got != "court\n" {
+		t.Errorf("lecture apres troncature = %q", got)
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// Un dossier sans List depuis cette duree sort du cache
+const dureeListeInutilisee = 10 * time.Minute
+
+// cacheListes garde la liste des fichiers des dossiers servis, partagee par
+// toutes les sessions : List ne relit pas le dossier a chaque appel.
+//
+// surveiller verifie les dossiers a intervalle regulier : un dossier dont la
+// date de modification a change (fichier ajoute, supprime ou renomme) est
+// relu. Seule la date du dossier est lue, sans stat par fichier : un fichier
+// modifie sur place n'est vu que si les fichiers sont aussi verifies un par
+// un (taille et date), pour tous les dossiers (-listing-check-files) ou pour
+// ceux observes par Watch. Les differences trouvees sont publiees sur le bus
+// (evFichier), pour les clients en mode Watch.
+type cacheListes struct {
+	mu       sync.Mutex
+	dossiers map[string]*listeDossier
+	// Nombre de sessions Watch par dossier : ces dossiers restent en cache
+	observes map[string]int
+	bus      *busEvenements
+}
+
+type listeDossier struct {
+	// Fichiers tries par nom ; la liste n'est plus modifiee une fois publiee
+	infos []fs.FileInfo
+	// Date de modification du dossier lors de la lecture
+	modif time.Time
+	// Dernier List
+	utilise time.Time
+}
+
+func newCacheListes(bus *busEvenements) *cacheListes {
+	return &cacheListes{dossiers: make(map[string]*listeDossier), observes: make(map[string]int), bus: bus}
+}
+
+// lister retourne les fichiers de dir, lus au premier appel puis gardes en cache.
+// La liste retournee ne doit pas etre modifiee.
+func (c *cacheListes) lister(dir string) ([]fs.FileInfo, error) {
+	c.mu.Lock()
+	if l, ok := c.dossiers[dir]; ok {
+		l.utilise = time.Now()
+		c.mu.Unlock()
+		return l.infos, nil
+	}
+	c.mu.Unlock()
+
+	l, err := lireListe(dir)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dossiers[dir] = l
+	return l.infos, nil
+}
+
+// observer garde dir en cache (et donc verifie) jusqu'a l'appel de la
+// fonction retournee.
+func (c *cacheListes) observer(dir string) (func(), error) {
+	if _, err := c.lister(dir); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observes[dir]++
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.observes[dir]--; c.observes[dir] == 0 {
+			delete(c.observes, dir)
+		}
+	}, nil
+}
+
+// rescanner relit dir, ou tous les dossiers en cache si dir est vide.
+// Retourne le nombre de dossiers relus.
+func (c *cacheListes) rescanner(dir string) (int, error) {
+	dossiers := []string{dir}
+	if dir == "" {
+		dossiers = c.connus()
+	}
+	for _, d := range dossiers {
+		l, err := lireListe(d)
+		c.mu.Lock()
+		ancienne := c.dossiers[d]
+		if err != nil {
+			delete(c.dossiers, d)
+		} else {
+			c.dossiers[d] = l
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if ancienne != nil {
+			c.publierDifferences(d, ancienne.infos, l.infos)
+		}
+	}
+	return len(dossiers), nil
+}
+
+func (c *cacheListes) connus() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dossiers := make([]string, 0, len(c.dossiers))
+	for d := range c.dossiers {
+		dossiers = append(dossiers, d)
+	}
+	return dossiers
+}
+
+// surveiller verifie les dossiers en cache toutes les intervalle, jusqu'a
+// arret ; fichiers indique si chaque fichier est aussi verifie.
+func (c *cacheListes) surveiller(intervalle time.Duration, fichiers bool, arret chan struct{}) {
+	ticker := time.NewTicker(intervalle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-arret:
+			return
+		case <-ticker.C:
+			c.verifier(fichiers)
+		}
+	}
+}
+
+// verifier relit les dossiers qui ont change et oublie ceux qui ne sont plus
+// listes. Les fichiers des dossiers observes (Watch) sont toujours verifies.
+func (c *cacheListes) verifier(fichiers bool) {
+	c.mu.Lock()
+	copie := make(map[string]*listeDossier, len(c.dossiers))
+	parFichier := make(map[string]bool, len(c.dossiers))
+	for d, l := range c.dossiers {
+		if time.Since(l.utilise) > dureeListeInutilisee && c.observes[d] == 0 {
+			delete(c.dossiers, d)
+			continue
+		}
+		copie[d] = l
+		parFichier[d] = fichiers || c.observes[d] > 0
+	}
+	c.mu.Unlock()
+
+	// Les verifications se font hors verrou : List n'attend pas le disque
+	for d, l := range copie {
+		if !aChange(d, l, parFichier[d]) {
+			continue
+		}
+		nouvelle, err := lireListe(d)
+		c.mu.Lock()
+		// Un Rescan a pu remplacer la liste entre-temps
+		remplace := c.dossiers[d] == l
+		if remplace {
+			if err != nil {
+				delete(c.dossiers, d)
+			} else {
+				nouvelle.utilise = l.utilise
+				c.dossiers[d] = nouvelle
+			}
+		}
+		c.mu.Unlock()
+		if remplace && err == nil {
+			c.publierDifferences(d, l.infos, nouvelle.infos)
+		}
+	}
+}
+
+// publierDifferences publie les fichiers ajoutes, supprimes et modifies
+// entre deux listes de dir (triees par nom).
+func (c *cacheListes) publierDifferences(dir string, avant, apres []fs.FileInfo) {
+	publier := func(action, nom string) {
+		ev := changementFichier(action, nom)
+		ev.dossier = dir
+		c.bus.publier(ev)
+	}
+	i, j := 0, 0
+	for i < len(avant) || j < len(apres) {
+		switch {
+		case j == len(apres) || (i < len(avant) && avant[i].Name() < apres[j].Name()):
+			publier(proto.EvenementSuppression, avant[i].Name())
+			i++
+		case i == len(avant) || apres[j].Name() < avant[i].Name():
+			publier(proto.EvenementAjout, apres[j].Name())
+			j++
+		default:
+			if avant[i].Size() != apres[j].Size() || !avant[i].ModTime().Equal(apres[j].ModTime()) {
+				publier(proto.EvenementModification, apres[j].Name())
+			}
+			i++
+			j++
+		}
+	}
+}
+
+// aChange indique si le contenu de dir differe de la liste l : sa date de
+// modification, et si fichiers est vrai la taille et la date de chaque fichier.
+func aChange(dir string, l *listeDossier, fichiers bool) bool {
+	info, err := os.Stat(dir)
+	if err != nil || !info.ModTime().Equal(l.modif) {
+		return true
+	}
+	if !fichiers {
+		return false
+	}
+	for _, f := range l.infos {
+		actuel, err := os.Stat(filepath.Join(dir, f.Name()))
+		if err != nil || actuel.Size() != f.Size() || !actuel.ModTime().Equal(f.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+// lireListe lit les fichiers de dir. La date du dossier est lue avant son
+// contenu : un changement pendant la lecture sera vu a la verification suivante.
+func lireListe(dir string) (*listeDossier, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := lireDossier(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &listeDossier{infos: infos, modif: info.ModTime(), utilise: time.Now()}, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListeAChange(t *testing.T) {
+	dir := t.TempDir()
+	chemin := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(chemin, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := lireListe(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aChange(dir, l, false) || aChange(dir, l, true) {
+		t.Fatal("dossier inchange signale comme modifie")
+	}
+
+	// Fichier modifie sur place : la date du dossier ne change pas
+	if err := os.WriteFile(chemin, []byte("plus long"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if aChange(dir, l, false) {
+		t.Error("modification sur place vue sans verification des fichiers")
+	}
+	if !aChange(dir, l, true) {
+		t.Error("modification sur place non vue avec verification des fichiers")
+	}
+
+	// Fichier ajoute : la date du dossier change
+	futur := time.Now().Add(time.Hour)
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dir, futur, futur); err != nil {
+		t.Fatal(err)
+	}
+	if !aChange(dir, l, false) {
+		t.Error("ajout d'un fichier non vu")
+	}
+}
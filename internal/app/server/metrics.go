@@ -0,0 +1,166 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Types d'erreurs comptees par les metriques
+const (
+	erreurConnexion = "connection" // lecture d'une commande impossible
+	erreurEnvoi     = "send"       // envoi d'une reponse ou d'un fichier impossible
+	erreurFichier   = "filesystem" // lecture du dossier ou d'un fichier impossible
+	erreurJournal   = "access_log" // ecriture du journal d'acces impossible
+	erreurAccept    = "accept"     // acceptation d'une connexion impossible
+)
+
+// Nom utilise dans les metriques pour les commandes inconnues
+const commandeAutre = "other"
+
+// Bornes (en secondes) de l'histogramme des durees de transfert
+var bornesDurees = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// Cle des compteurs de commandes
+type cleCommande struct {
+	commande, resultat string
+}
+
+// metriques regroupe les compteurs du serveur, exposes au format texte
+// de Prometheus par ServeHTTP. Les methodes sont utilisables depuis
+// plusieurs goroutines.
+type metriques struct {
+	mu               sync.Mutex
+	clientsConnectes int
+	connexionsTotal  int64
+	commandes        map[cleCommande]int64
+	octetsEnvoyes    int64
+	fichiersCaches   int
+	erreurs          map[string]int64
+	// Histogramme des durees de transfert : un compteur par borne (cumulatif
+	// a l'affichage), plus la somme et le nombre d'observations
+	durees      []int64
+	sommeDurees float64
+	nbDurees    int64
+}
+
+func newMetriques() *metriques {
+	return &metriques{
+		commandes: make(map[cleCommande]int64),
+		erreurs:   make(map[string]int64),
+		durees:    make([]int64, len(bornesDurees)),
+	}
+}
+
+func (m *metriques) setClientsConnectes(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clientsConnectes = n
+}
+
+func (m *metriques) connexion() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connexionsTotal++
+}
+
+func (m *metriques) commande(commande, resultat string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commandes[cleCommande{commande, resultat}]++
+}
+
+// transfert enregistre les octets envoyes et la duree d'un Get.
+func (m *metriques) transfert(octets int64, duree time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.octetsEnvoyes += octets
+
+	s := duree.Seconds()
+	for i, borne := range bornesDurees {
+		if s <= borne {
+			m.durees[i]++
+			break
+		}
+	}
+	m.sommeDurees += s
+	m.nbDurees++
+}
+
+func (m *metriques) setFichiersCaches(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fichiersCaches = n
+}
+
+func (m *metriques) erreur(genre string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.erreurs[genre]++
+}
+
+// ServeHTTP ecrit les metriques au format texte de Prometheus.
+func (m *metriques) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.ecrire(w)
+}
+
+func (m *metriques) ecrire(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entete := func(nom, genre, aide string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", nom, aide, nom, genre)
+	}
+
+	entete("fileserver_connected_clients", "gauge", "Number of clients currently served.")
+	fmt.Fprintf(w, "fileserver_connected_clients %d\n", m.clientsConnectes)
+
+	entete("fileserver_connections_total", "counter", "Number of connections accepted on the data port.")
+	fmt.Fprintf(w, "fileserver_connections_total %d\n", m.connexionsTotal)
+
+	entete("fileserver_commands_total", "counter", "Number of commands handled, by command and outcome.")
+	cles := make([]cleCommande, 0, len(m.commandes))
+	for k := range m.commandes {
+		cles = append(cles, k)
+	}
+	sort.Slice(cles, func(i, j int) bool {
+		if cles[i].commande != cles[j].commande {
+			return cles[i].commande < cles[j].commande
+		}
+		return cles[i].resultat < cles[j].resultat
+	})
+	for _, k := range cles {
+		fmt.Fprintf(w, "fileserver_commands_total{command=%q,outcome=%q} %d\n", k.commande, k.resultat, m.commandes[k])
+	}
+
+	entete("fileserver_sent_bytes_total", "counter", "Number of file bytes sent on the wire.")
+	fmt.Fprintf(w, "fileserver_sent_bytes_total %d\n", m.octetsEnvoyes)
+
+	entete("fileserver_transfer_duration_seconds", "histogram", "Duration of Get transfers.")
+	var cumul int64
+	for i, borne := range bornesDurees {
+		cumul += m.durees[i]
+		fmt.Fprintf(w, "fileserver_transfer_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(borne, 'g', -1, 64), cumul)
+	}
+	fmt.Fprintf(w, "fileserver_transfer_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.nbDurees)
+	fmt.Fprintf(w, "fileserver_transfer_duration_seconds_sum %s\n", strconv.FormatFloat(m.sommeDurees, 'g', -1, 64))
+	fmt.Fprintf(w, "fileserver_transfer_duration_seconds_count %d\n", m.nbDurees)
+
+	entete("fileserver_hidden_files", "gauge", "Number of hidden files.")
+	fmt.Fprintf(w, "fileserver_hidden_files %d\n", m.fichiersCaches)
+
+	entete("fileserver_errors_total", "counter", "Number of errors, by kind.")
+	genres := make([]string, 0, len(m.erreurs))
+	for k := range m.erreurs {
+		genres = append(genres, k)
+	}
+	sort.Strings(genres)
+	for _, k := range genres {
+		fmt.Fprintf(w, "fileserver_errors_total{kind=%q} %d\n", k, m.erreurs[k])
+	}
+}
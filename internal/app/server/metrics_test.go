@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lireMetriques interroge ServeHTTP et renvoie la reponse.
+func lireMetriques(t *testing.T, m *metriques) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("statut %d, attendu %d", rec.Code, http.StatusOK)
+	}
+	return rec
+}
+
+func TestMetriquesFormat(t *testing.T) {
+	rec := lireMetriques(t, newMetriques())
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	corps := rec.Body.String()
+	for _, m := range []struct{ nom, genre string }{
+		{"fileserver_connected_clients", "gauge"},
+		{"fileserver_connections_total", "counter"},
+		{"fileserver_commands_total", "counter"},
+		{"fileserver_sent_bytes_total", "counter"},
+		{"fileserver_transfer_duration_seconds", "histogram"},
+		{"fileserver_hidden_files", "gauge"},
+		{"fileserver_errors_total", "counter"},
+	} {
+		if !strings.Contains(corps, "# HELP "+m.nom+" ") {
+			t.Errorf("ligne HELP absente pour %s", m.nom)
+		}
+		if !strings.Contains(corps, "# TYPE "+m.nom+" "+m.genre+"\n") {
+			t.Errorf("ligne TYPE %s absente pour %s", m.genre, m.nom)
+		}
+	}
+}
+
+func TestMetriquesCompteurs(t *testing.T) {
+	m := newMetriques()
+	m.connexion()
+	m.connexion()
+	m.setClientsConnectes(1)
+	m.setFichiersCaches(3)
+	m.commande("Get", "ok")
+	m.commande("Get", "ok")
+	m.commande("List", "error")
+	m.transfert(1000, 20*time.Millisecond)
+	m.transfert(500, 2*time.Second)
+	m.erreur(erreurEnvoi)
+
+	corps := lireMetriques(t, m).Body.String()
+	for _, ligne := range []string{
+		"fileserver_connected_clients 1",
+		"fileserver_connections_total 2",
+		`fileserver_commands_total{command="Get",outcome="ok"} 2`,
+		`fileserver_commands_total{command="List",outcome="error"} 1`,
+		"fileserver_sent_bytes_total 1500",
+		`fileserver_transfer_duration_seconds_bucket{le="0.01"} 0`,
+		`fileserver_transfer_duration_seconds_bucket{le="0.05"} 1`,
+		`fileserver_transfer_duration_seconds_bucket{le="1"} 1`,
+		`fileserver_transfer_duration_seconds_bucket{le="5"} 2`,
+		`fileserver_transfer_duration_seconds_bucket{le="+Inf"} 2`,
+		"fileserver_transfer_duration_seconds_sum 2.02",
+		"fileserver_transfer_duration_seconds_count 2",
+		"fileserver_hidden_files 3",
+		`fileserver_errors_total{kind="send"} 1`,
+	} {
+		if !strings.Contains(corps, ligne+"\n") {
+			t.Errorf("ligne %q absente de :\n%s", ligne, corps)
+		}
+	}
+
+	// Les compteurs augmentent avec les evenements suivants
+	m.commande("Get", "ok")
+	m.erreur(erreurEnvoi)
+	m.transfert(250, time.Millisecond)
+	corps = lireMetriques(t, m).Body.String()
+	for _, ligne := range []string{
+		`fileserver_commands_total{command="Get",outcome="ok"} 3`,
+		`fileserver_errors_total{kind="send"} 2`,
+		"fileserver_sent_bytes_total 1750",
+		"fileserver_transfer_duration_seconds_count 3",
+	} {
+		if !strings.Contains(corps, ligne+"\n") {
+			t.Errorf("ligne %q absente apres de nouveaux evenements", ligne)
+		}
+	}
+}
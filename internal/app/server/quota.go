@@ -0,0 +1,298 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefixe des fichiers temporaires d'un envoi en cours (Put), jamais listes
+const prefixeEnvoi = ".put-"
+
+// Portees d'un depassement de quota (reponse "QuotaExceeded <portee> <limite>")
+const (
+	quotaFichier     = "file"  // taille maximale d'un fichier (Config.MaxFileSize)
+	quotaPartage     = "share" // quota du partage (Share.Quota)
+	quotaUtilisateur = "user"  // quota par utilisateur (Config.UserQuota)
+)
+
+// erreurQuota signale un envoi refuse parce qu'il depasserait une limite.
+type erreurQuota struct {
+	portee string
+	limite int64
+}
+
+func (e *erreurQuota) Error() string {
+	return fmt.Sprintf("%s quota exceeded (limit %d bytes)", e.portee, e.limite)
+}
+
+// Age maximal de la mesure du dossier d'un partage : au-dela, le dossier est
+// mesure a nouveau, pour tenir compte des fichiers modifies hors du serveur
+const dureeMesurePartage = time.Minute
+
+// quotas suit l'espace utilise par les envois (Put), pour les partages et
+// pour les utilisateurs.
+//
+// L'utilisation d'un partage est la taille des fichiers de son dossier. Elle
+// est mesuree sur le disque au plus toutes les dureeMesurePartage, hors du
+// verrou, et tenue a jour entre-temps par les envois et suppressions du
+// serveur ; un fichier modifie par un autre programme n'est donc compte
+// qu'a la mesure suivante.
+//
+// Sans authentification, un utilisateur est une adresse IP de client : les
+// clients derriere une meme adresse (NAT, proxy) partagent un quota.
+// L'utilisation d'un utilisateur est la taille des fichiers qu'il a envoyes
+// depuis le demarrage du serveur et qui existent encore ; elle est gardee
+// en memoire seulement, et repart de zero au redemarrage.
+//
+// Les envois en cours reservent la taille annoncee, qui ne peut pas etre
+// depassee pendant le transfert.
+type quotas struct {
+	mu sync.Mutex
+	// Octets reserves par les envois en cours
+	reservePartage     map[string]int64
+	reserveUtilisateur map[string]int64
+	// Utilisation des dossiers, par partage
+	partages map[string]*utilisationPartage
+	// Fichiers envoyes, par partage puis par nom
+	envoyes map[string]map[string]fichierEnvoye
+}
+
+type fichierEnvoye struct {
+	utilisateur string
+	taille      int64
+}
+
+// utilisationPartage est la taille des fichiers du dossier d'un partage.
+type utilisationPartage struct {
+	dossier string
+	octets  int64
+	// Date de la derniere mesure sur le disque (zero = jamais mesure)
+	mesure time.Time
+	// Pendant une mesure, les octets ajoutes sont aussi comptes dans ajouts :
+	// la mesure a pu ne pas les voir
+	enCours bool
+	ajouts  int64
+	// Une seule mesure a la fois par partage, hors du verrou des quotas
+	mesureMu sync.Mutex
+}
+
+// ajouter compte n octets ajoutes (ou retires si n < 0) dans le dossier.
+func (u *utilisationPartage) ajouter(n int64) {
+	u.octets += n
+	if u.enCours && n > 0 {
+		u.ajouts += n
+	}
+}
+
+// reservation est l'espace retenu par un envoi en cours.
+type reservation struct {
+	partage     string
+	dossier     string
+	utilisateur string
+	taille      int64
+}
+
+func newQuotas() *quotas {
+	return &quotas{
+		reservePartage:     make(map[string]int64),
+		reserveUtilisateur: make(map[string]int64),
+		partages:           make(map[string]*utilisationPartage),
+		envoyes:            make(map[string]map[string]fichierEnvoye),
+	}
+}
+
+// partageLocked retourne l'utilisation du partage, remise a zero si son
+// dossier a change (rechargement de la configuration).
+func (q *quotas) partageLocked(share Share) *utilisationPartage {
+	u := q.partages[share.Name]
+	if u == nil || u.dossier != share.Path {
+		u = &utilisationPartage{dossier: share.Path}
+		q.partages[share.Name] = u
+	}
+	return u
+}
+
+// utilisation retourne la taille des fichiers du dossier du partage, mesuree
+// sur le disque si la derniere mesure date de plus de dureeMesurePartage.
+// Le parcours du dossier se fait hors du verrou : les autres envois et la
+// commande Quota n'attendent pas le disque.
+func (q *quotas) utilisation(share Share) (int64, error) {
+	q.mu.Lock()
+	u := q.partageLocked(share)
+	q.mu.Unlock()
+
+	u.mesureMu.Lock()
+	defer u.mesureMu.Unlock()
+	q.mu.Lock()
+	if !u.mesure.IsZero() && time.Since(u.mesure) < dureeMesurePartage {
+		defer q.mu.Unlock()
+		return u.octets, nil
+	}
+	u.enCours, u.ajouts = true, 0
+	q.mu.Unlock()
+
+	total, err := utilisationDossier(share.Path)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u.enCours = false
+	if err != nil {
+		return 0, err
+	}
+	// Un fichier ajoute pendant le parcours peut etre compte deux fois :
+	// l'utilisation est surestimee jusqu'a la mesure suivante, jamais sous-estimee
+	u.octets = total + u.ajouts
+	u.mesure = time.Now()
+	return u.octets, nil
+}
+
+// reserver verifie que le fichier nom (taille octets, ecrit a chemin) peut
+// etre envoye dans le partage par l'utilisateur, et retient cet espace
+// jusqu'a liberer. Le fichier existant a chemin sera remplace par l'envoi.
+// Retourne une *erreurQuota si une limite serait depassee.
+//
+// Un envoi termine est compte dans l'utilisation du partage par liberer,
+// avant que sa reservation soit rendue : deux envois simultanes ne peuvent
+// pas depasser ensemble le quota du partage.
+func (q *quotas) reserver(config *Config, share Share, utilisateur string, nom string, chemin string, taille int64) (*reservation, error) {
+	if config.MaxFileSize > 0 && taille > config.MaxFileSize {
+		return nil, &erreurQuota{portee: quotaFichier, limite: config.MaxFileSize}
+	}
+	var remplace int64
+	if share.Quota > 0 {
+		if _, err := q.utilisation(share); err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(chemin); err == nil && info.Mode().IsRegular() {
+			remplace = info.Size()
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if share.Quota > 0 && q.partageLocked(share).octets-remplace+q.reservePartage[share.Name]+taille > share.Quota {
+		return nil, &erreurQuota{portee: quotaPartage, limite: share.Quota}
+	}
+	utilisateurActuel := q.utilisationLocked(utilisateur)
+	if e, ok := q.envoyes[share.Name][nom]; ok && e.utilisateur == utilisateur {
+		utilisateurActuel -= e.taille
+	}
+	if config.UserQuota > 0 && utilisateurActuel+taille > config.UserQuota {
+		return nil, &erreurQuota{portee: quotaUtilisateur, limite: config.UserQuota}
+	}
+	q.reservePartage[share.Name] += taille
+	q.reserveUtilisateur[utilisateur] += taille
+	return &reservation{partage: share.Name, dossier: share.Path, utilisateur: utilisateur, taille: taille}, nil
+}
+
+// liberer rend l'espace reserve. Si l'envoi a reussi, le fichier nom
+// (taille octets) est compte pour l'utilisateur et dans le partage, a la
+// place de l'ancien fichier de ce nom (remplace octets).
+func (q *quotas) liberer(r *reservation, nom string, taille int64, remplace int64, reussi bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reservePartage[r.partage] -= r.taille
+	q.reserveUtilisateur[r.utilisateur] -= r.taille
+	if !reussi {
+		return
+	}
+	if u := q.partages[r.partage]; u != nil && u.dossier == r.dossier {
+		u.ajouter(taille - remplace)
+	}
+	if q.envoyes[r.partage] == nil {
+		q.envoyes[r.partage] = make(map[string]fichierEnvoye)
+	}
+	q.envoyes[r.partage][nom] = fichierEnvoye{utilisateur: r.utilisateur, taille: taille}
+}
+
+// supprime oublie le fichier (ou le dossier) nom du partage, qui occupait
+// taille octets.
+func (q *quotas) supprime(partage, nom string, taille int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u := q.partages[partage]; u != nil {
+		u.ajouter(-taille)
+	}
+	for f := range q.envoyes[partage] {
+		if f == nom || strings.HasPrefix(f, nom+"/") {
+			delete(q.envoyes[partage], f)
+		}
+	}
+}
+
+// renomme suit un fichier (ou un dossier) renomme dans le partage.
+func (q *quotas) renomme(partage, ancien, nouveau string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	fichiers := q.envoyes[partage]
+	// Un fichier remplace par le renommage n'existe plus
+	delete(fichiers, nouveau)
+	deplaces := make(map[string]fichierEnvoye)
+	for f, e := range fichiers {
+		if f == ancien || strings.HasPrefix(f, ancien+"/") {
+			delete(fichiers, f)
+			deplaces[nouveau+strings.TrimPrefix(f, ancien)] = e
+		}
+	}
+	for f, e := range deplaces {
+		fichiers[f] = e
+	}
+}
+
+func (q *quotas) utilisationLocked(utilisateur string) int64 {
+	total := q.reserveUtilisateur[utilisateur]
+	for _, fichiers := range q.envoyes {
+		for _, e := range fichiers {
+			if e.utilisateur == utilisateur {
+				total += e.taille
+			}
+		}
+	}
+	return total
+}
+
+// utilisateurs retourne l'utilisation de chaque utilisateur qui a envoye
+// des fichiers (ou envoie un fichier).
+func (q *quotas) utilisateurs() map[string]int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	utilisation := make(map[string]int64)
+	for u, r := range q.reserveUtilisateur {
+		if r > 0 {
+			utilisation[u] += r
+		}
+	}
+	for _, fichiers := range q.envoyes {
+		for _, e := range fichiers {
+			utilisation[e.utilisateur] += e.taille
+		}
+	}
+	return utilisation
+}
+
+// utilisationDossier retourne la taille des fichiers de dir et de ses sous-dossiers.
+func utilisationDossier(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(chemin string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Les envois en cours sont comptes par leur reservation
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), prefixeEnvoi) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Fichier supprime pendant le parcours
+			return nil
+		}
+		total += info.Size()
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// envoyer simule un Put reussi : reservation, ecriture, puis liberation.
+func envoyer(q *quotas, share Share, utilisateur, nom string, taille int64) error {
+	chemin := filepath.Join(share.Path, nom)
+	res, err := q.reserver(&Config{}, share, utilisateur, nom, chemin, taille)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(share.Path, prefixeEnvoi+nom)
+	err = os.WriteFile(tmp, bytes.Repeat([]byte("x"), int(taille)), 0o644)
+	var remplace int64
+	if info, e := os.Stat(chemin); e == nil {
+		remplace = info.Size()
+	}
+	if err == nil {
+		err = os.Rename(tmp, chemin)
+	}
+	q.liberer(res, nom, taille, remplace, err == nil)
+	return err
+}
+
+func TestQuotaPartage(t *testing.T) {
+	share := Share{Name: "test", Path: t.TempDir(), Quota: 1000}
+	q := newQuotas()
+	if err := os.WriteFile(filepath.Join(share.Path, "existant.txt"), make([]byte, 600), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un envoi en cours compte par sa reservation
+	res, err := q.reserver(&Config{}, share, "a", "a.txt", filepath.Join(share.Path, "a.txt"), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quota *erreurQuota
+	if _, err := q.reserver(&Config{}, share, "b", "b.txt", filepath.Join(share.Path, "b.txt"), 200); !errors.As(err, &quota) || quota.portee != quotaPartage {
+		t.Errorf("envoi au-dela du quota : erreur %v, attendu un depassement du partage", err)
+	}
+	q.liberer(res, "a.txt", 0, 0, false)
+
+	// Remplacer un fichier libere sa taille
+	if err := envoyer(q, share, "b", "existant.txt", 1000); err != nil {
+		t.Errorf("remplacement dans la limite refuse : %v", err)
+	}
+	if err := envoyer(q, share, "b", "b.txt", 1); !errors.As(err, &quota) {
+		t.Errorf("partage plein : erreur %v, attendu un depassement", err)
+	}
+}
+
+func TestQuotaPartageEnvoisSimultanes(t *testing.T) {
+	share := Share{Name: "test", Path: t.TempDir(), Quota: 1000}
+	q := newQuotas()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			envoyer(q, share, fmt.Sprint("client", i), fmt.Sprintf("f%02d.txt", i), 100)
+		}()
+	}
+	wg.Wait()
+
+	utilise, err := utilisationDossier(share.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utilise != share.Quota {
+		t.Errorf("%d octets dans le partage, attendu exactement le quota (%d)", utilise, share.Quota)
+	}
+}
+
+func TestQuotaUtilisationTenueAJour(t *testing.T) {
+	share := Share{Name: "test", Path: t.TempDir(), Quota: 1000}
+	q := newQuotas()
+	if err := envoyer(q, share, "a", "a.txt", 300); err != nil {
+		t.Fatal(err)
+	}
+
+	// Un fichier ajoute hors du serveur n'est vu qu'a la mesure suivante
+	if err := os.WriteFile(filepath.Join(share.Path, "externe.txt"), make([]byte, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if utilise, _ := q.utilisation(share); utilise != 300 {
+		t.Errorf("utilisation %d, attendu 300 (sans nouvelle mesure)", utilise)
+	}
+
+	// Les suppressions du serveur sont comptees aussitot
+	q.supprime(share.Name, "a.txt", 300)
+	if err := os.Remove(filepath.Join(share.Path, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if utilise, _ := q.utilisation(share); utilise != 0 {
+		t.Errorf("utilisation %d apres suppression, attendu 0", utilise)
+	}
+
+	q.mu.Lock()
+	q.partages[share.Name].mesure = time.Now().Add(-dureeMesurePartage)
+	q.mu.Unlock()
+	if utilise, _ := q.utilisation(share); utilise != 200 {
+		t.Errorf("utilisation %d apres nouvelle mesure, attendu 200", utilise)
+	}
+}
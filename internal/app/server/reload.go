@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
+)
+
+// recharger relit la configuration (Config.Charger) et l'applique d'un bloc :
+// si elle est invalide, rien n'est change et l'erreur est retournee.
+//
+// Les nouvelles sessions utilisent la nouvelle configuration, et les sessions
+// en cours lors de leur prochaine commande Use. Les limites de debit et de
+// clients, les regles d'acces, les fichiers caches et les niveaux de log
+// s'appliquent aussi aux sessions en cours. Les ports, le journal d'acces,
+// les metriques, le tableau de bord, le cache des listes et la sortie des
+// logs ne changent qu'au redemarrage.
+//
+// Retourne la description des changements ("cle: avant -> apres").
+func recharger(state *ServerState, hiddenManager chan interface{}) ([]string, error) {
+	state.rechargement.Lock()
+	defer state.rechargement.Unlock()
+
+	ancienne := state.config.Load()
+	if ancienne.Charger == nil {
+		return nil, errors.New("configuration reload not available")
+	}
+	nouvelle, err := ancienne.Charger()
+	if err != nil {
+		return nil, err
+	}
+	nouvelle.Charger = ancienne.Charger
+
+	// Tout ce qui peut echouer est fait avant d'appliquer quoi que ce soit
+	regles, err := chargerRegles(nouvelle.AclFile)
+	if err != nil {
+		return nil, err
+	}
+
+	changements := differences(ancienne, &nouvelle)
+
+	if err := logging.SetLevels(nouvelle.Log.Level, nouvelle.Log.Subsystems); err != nil {
+		return nil, err
+	}
+	state.filtre.remplacer(regles)
+	state.debit.global.Store(nouvelle.GlobalRate)
+	state.debit.parClient.Store(nouvelle.ClientRate)
+
+	reqLimite := limiteRequest{limite: nouvelle.MaxClients, response: make(chan bool)}
+	state.admission <- reqLimite
+	<-reqLimite.response
+	reqFile := tailleFileRequest{tailleFile: nouvelle.QueueSize, response: make(chan bool)}
+	state.admission <- reqFile
+	<-reqFile.response
+
+	reqMotifs := setHidePatternsRequest{motifs: nouvelle.Hide, response: make(chan bool)}
+	hiddenManager <- reqMotifs
+	<-reqMotifs.response
+
+	state.config.Store(&nouvelle)
+	return changements, nil
+}
+
+// differences liste les changements entre deux configurations. Les parametres
+// qui demandent un redemarrage gardent leur ancienne valeur dans nouvelle.
+func differences(ancienne, nouvelle *Config) []string {
+	var changements []string
+	champ := func(nom string, avant, apres any) bool {
+		a, b := fmt.Sprint(avant), fmt.Sprint(apres)
+		if a == b {
+			return false
+		}
+		changements = append(changements, fmt.Sprintf("%s: %s -> %s", nom, a, b))
+		return true
+	}
+	redemarrage := func(nom string, avant, apres any) bool {
+		if !champ(nom, avant, apres) {
+			return false
+		}
+		changements[len(changements)-1] += " (restart required)"
+		return true
+	}
+
+	champ("dir", ancienne.Dir, nouvelle.Dir)
+	champ("shares", ancienne.Shares, nouvelle.Shares)
+	champ("hide", ancienne.Hide, nouvelle.Hide)
+	champ("transfer.chunked", ancienne.Chunked, nouvelle.Chunked)
+	champ("transfer.compress", ancienne.Compress, nouvelle.Compress)
+	champ("transfer.compress_min_size", ancienne.CompressMinSize, nouvelle.CompressMinSize)
+	champ("limits.rate", ancienne.GlobalRate, nouvelle.GlobalRate)
+	champ("limits.client_rate", ancienne.ClientRate, nouvelle.ClientRate)
+	champ("limits.max_clients", ancienne.MaxClients, nouvelle.MaxClients)
+	champ("limits.queue", ancienne.QueueSize, nouvelle.QueueSize)
+	champ("limits.acl", ancienne.AclFile, nouvelle.AclFile)
+	champ("limits.user_quota", ancienne.UserQuota, nouvelle.UserQuota)
+	champ("limits.max_file_size", ancienne.MaxFileSize, nouvelle.MaxFileSize)
+	champ("timeouts.idle", ancienne.IdleTimeout, nouvelle.IdleTimeout)
+	champ("log.level", ancienne.Log.Level, nouvelle.Log.Level)
+	champ("log.subsystems", ancienne.Log.Subsystems, nouvelle.Log.Subsystems)
+
+	if redemarrage("listen.port", ancienne.Port, nouvelle.Port) {
+		nouvelle.Port = ancienne.Port
+	}
+	if redemarrage("listen.control_port", ancienne.ControlPort, nouvelle.ControlPort) {
+		nouvelle.ControlPort = ancienne.ControlPort
+	}
+	if redemarrage("listen.metrics", ancienne.MetricsAddr, nouvelle.MetricsAddr) {
+		nouvelle.MetricsAddr = ancienne.MetricsAddr
+	}
+	if redemarrage("access_log.file", ancienne.AccessLog, nouvelle.AccessLog) {
+		nouvelle.AccessLog = ancienne.AccessLog
+	}
+	if redemarrage("access_log.max_size", ancienne.AccessLogMaxSize, nouvelle.AccessLogMaxSize) {
+		nouvelle.AccessLogMaxSize = ancienne.AccessLogMaxSize
+	}
+	if redemarrage("access_log.max_age", ancienne.AccessLogMaxAge, nouvelle.AccessLogMaxAge) {
+		nouvelle.AccessLogMaxAge = ancienne.AccessLogMaxAge
+	}
+	if redemarrage("log.format", ancienne.Log.Format, nouvelle.Log.Format) {
+		nouvelle.Log.Format = ancienne.Log.Format
+	}
+	if redemarrage("log.file", ancienne.Log.File, nouvelle.Log.File) {
+		nouvelle.Log.File = ancienne.Log.File
+	}
+	if redemarrage("cache.listing_poll", ancienne.ListingPoll, nouvelle.ListingPoll) {
+		nouvelle.ListingPoll = ancienne.ListingPoll
+	}
+	if redemarrage("cache.check_files", ancienne.ListingCheckFiles, nouvelle.ListingCheckFiles) {
+		nouvelle.ListingCheckFiles = ancienne.ListingCheckFiles
+	}
+	if redemarrage("dashboard", ancienne.Dashboard, nouvelle.Dashboard) {
+		nouvelle.Dashboard = ancienne.Dashboard
+	}
+	return changements
+}
@@ -2,68 +2,219 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logctx"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
 	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
 	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/sendrec"
 )
 
-// Messages pour gerer les fichiers caches via canal
+// Messages pour gerer les fichiers caches via canal (un ensemble par partage)
 type hideRequest struct {
+	share    string
 	filename string
 	response chan bool
 }
 
 type revealRequest struct {
+	share    string
 	filename string
 	response chan bool
 }
 
 type isHiddenRequest struct {
+	share    string
 	filename string
 	response chan bool
 }
 
 type listHiddenRequest struct {
+	share    string
 	response chan map[string]bool
 }
 
+// Motifs de fichiers caches donnes par la configuration
+type hidePatternsRequest struct {
+	response chan []string
+}
+
+type setHidePatternsRequest struct {
+	motifs   []string
+	response chan bool
+}
+
+// Configuration du serveur
+type Config struct {
+	Port        string
+	ControlPort string
+	Dir         string
+	// Partages supplementaires, choisis par le client avec "Use <nom>"
+	Shares []Share
+	// Motifs (filepath.Match) des fichiers toujours caches
+	Hide []string
+	// Force le transfert par blocs pour toutes les reponses a Get
+	Chunked bool
+	// Autorise la compression des transferts si le client la supporte
+	Compress bool
+	// Taille minimale (en octets) d'un fichier pour qu'il soit compresse
+	CompressMinSize int64
+	// Debit maximal en octets/s, pour tout le serveur et par connexion (0 = illimite)
+	GlobalRate int64
+	ClientRate int64
+	// Nombre maximal de clients servis simultanement (0 = illimite)
+	MaxClients int
+	// Nombre de clients pouvant attendre une place (0 = refus immediat)
+	QueueSize int
+	// Fichier de regles d'acces par adresse (voir chargerRegles)
+	AclFile string
+	// Journal d'acces (une ligne JSON par session, vide = desactive)
+	// et criteres de rotation (0 = pas de rotation selon ce critere)
+	AccessLog        string
+	AccessLogMaxSize int64
+	AccessLogMaxAge  time.Duration
+	// Adresse d'ecoute HTTP des metriques, ex. 127.0.0.1:9100 (vide = desactive)
+	MetricsAddr string
+	// Affiche un tableau de bord rafraichi en continu sur la sortie standard
+	Dashboard bool
+	// Deconnecte un client sans commande depuis cette duree (0 = jamais)
+	IdleTimeout time.Duration
+	// Intervalle de verification des listes de fichiers en cache (0 = pas de cache)
+	ListingPoll time.Duration
+	// Verifie aussi chaque fichier des listes en cache (fichiers modifies sur place)
+	ListingCheckFiles bool
+	// Espace maximal occupe par les fichiers envoyes (Put) par un meme client,
+	// et taille maximale d'un fichier envoye, en octets (0 = illimite)
+	UserQuota   int64
+	MaxFileSize int64
+	// Format, destination et niveaux des logs (appliques par cmd/server)
+	Log logging.Options
+	// Relit la configuration (SIGHUP ou commande Reload), nil = pas de rechargement
+	Charger func() (Config, error)
+}
+
 // Etat du serveur
 type ServerState struct {
-	shutdown chan struct{}
-	wg       sync.WaitGroup
+	// Configuration courante, remplacee d'un bloc par recharger
+	config    atomic.Pointer[Config]
+	// Un seul rechargement a la fois
+	rechargement sync.Mutex
+	// Arret du serveur (voir shutdown.go) : shutdown est ferme a la demande
+	// d'arret, immediat pour couper les connexions, termine une fois les
+	// clients deconnectes
+	arret         sync.Once
+	arretImmediat sync.Once
+	immediat      chan struct{}
+	termine       chan struct{}
+	shutdown  chan struct{}
+	wg        sync.WaitGroup
+	debit     *limitesDebit
+	admission chan interface{}
+	filtre    *filtreIP
+	accessLog *accessLog
+	// Archives servies par les partages, ouvertes une seule fois
+	archives  *cacheArchives
+	// Listes des dossiers servis (nil si le cache est desactive)
+	listes    *cacheListes
+	quotas    *quotas
+	// Serialise les renommages, suppressions et creations de dossiers
+	// (Rename, Delete, Mkdir) avec la fin des envois (Put) : Rename ne
+	// remplace jamais une destination creee entre sa verification et le
+	// renommage, et les tailles comptees dans les quotas restent exactes
+	renommages sync.Mutex
+	// Empreintes SHA-256 deja calculees (Hash)
+	empreintes *cacheEmpreintes
+	metriques *metriques
+	bus       *busEvenements
 }
 
-func gererClient(cnx net.Conn, nbClients chan int, dir string, hiddenManager chan interface{}, state *ServerState) {
+func gererClient(ctx context.Context, id string, cnx net.Conn, nbClients chan int, hiddenManager chan interface{}, state *ServerState) {
+	log := logctx.From(ctx)
 
-	// On signale +1 client
-	nbClients <- 1
 	state.wg.Add(1)
 
 	defer func() {
-		nbClients <- -1
 		state.wg.Done()
 		cnx.Close()
-		slog.Info("Connection closed", "client", cnx.RemoteAddr().String())
+		log.Info("Connection closed")
+	}()
+
+	log.Info("New client connected")
+
+	// Arret immediat : la connexion est fermee, meme pendant un transfert
+	fini := make(chan struct{})
+	defer close(fini)
+	go func() {
+		select {
+		case <-state.immediat:
+			cnx.Close()
+		case <-fini:
+		}
 	}()
 
-	slog.Info("New client connected", "client", cnx.RemoteAddr().String())
+	// Une ligne par session dans le journal d'acces, ecrite a la deconnexion
+	journal := newSessionRecord(id, cnx.RemoteAddr().String())
+	defer func() {
+		journal.Disconnected = time.Now()
+		if err := state.accessLog.ecrire(journal); err != nil {
+			log.Error("Failed to write access log", "error", err)
+			state.metriques.erreur(erreurJournal)
+		}
+	}()
 
 	reader := bufio.NewReader(cnx)
-	writer := bufio.NewWriter(cnx)
+	// Les envois passent par le limiteur de debit
+	writer := bufio.NewWriter(state.debit.writer(cnx))
+
+	// La session commence sur le partage par defaut, s'il est autorise au client
+	ip := adresseIP(cnx.RemoteAddr())
+	var courant *Share
+	if share, ok := state.config.Load().partage(partageParDefaut); ok && share.autorise(ip) {
+		courant = &share
+	}
+
+	// Attend une place si le nombre maximal de clients est atteint
+	if !attendrePlace(ctx, cnx, writer, state) {
+		return
+	}
+	defer func() {
+		state.admission <- sortirRequest{}
+	}()
+
+	// On signale +1 client
+	nbClients <- 1
+	state.bus.publier(evenement{genre: evConnexion, session: id, client: journal.Client})
+	defer func() {
+		nbClients <- -1
+		state.bus.publier(evenement{genre: evDeconnexion, session: id, client: journal.Client})
+	}()
 
+	derniereCommande := time.Now()
 	for {
 		// Verifie si shutdown demande
 		select {
 		case <-state.shutdown:
-			slog.Debug("Client handler shutting down", "client", cnx.RemoteAddr().String())
+			log.Debug("Client handler shutting down")
 			return
 		default:
 		}
@@ -75,14 +226,22 @@ func gererClient(cnx net.Conn, nbClients chan int, dir string, hiddenManager cha
 		cmdLine, err := reader.ReadString('\n')
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// Client inactif trop longtemps
+				idle := state.config.Load().IdleTimeout
+				if idle > 0 && time.Since(derniereCommande) > idle {
+					log.Info("Idle timeout, disconnecting client", "idle", idle)
+					return
+				}
 				continue
 			}
-			slog.Error("Connection error", "error", err)
+			log.Error("Connection error", "error", err)
+			state.metriques.erreur(erreurConnexion)
 			return
 		}
 
 		// Enleve le timeout pour traiter la commande
 		cnx.SetReadDeadline(time.Time{})
+		derniereCommande = time.Now()
 
 		cmdLine = strings.TrimSpace(cmdLine)
 		if cmdLine == "" {
@@ -92,51 +251,227 @@ func gererClient(cnx net.Conn, nbClients chan int, dir string, hiddenManager cha
 		parts := strings.Fields(cmdLine)
 		cmd := parts[0]
 
-		slog.Debug("Received command",
+		log.Debug("Received command",
 			"command", cmd,
 			"args", parts[1:],
-			"from", cnx.RemoteAddr().String(),
 		)
+		state.bus.publier(evenement{genre: evCommande, session: id, client: journal.Client, texte: cmdLine})
 
 		switch cmd {
 
 		case proto.CommandeList:
-			commandList(reader, writer, dir, hiddenManager)
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandList(ctx, reader, writer, *courant, len(parts) >= 2 && parts[1] == proto.ListeDetaillee, hiddenManager, state))
 
 		case proto.CommandeGet:
 			if len(parts) < 2 {
-				slog.Warn("Get command missing filename")
+				log.Warn("Get command missing filename")
+				state.metriques.commande(cmd, resultatInvalide)
+				continue
+			}
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
 				continue
 			}
 			filename := parts[1]
-			commandGet(reader, writer, dir, filename, cnx.RemoteAddr().String(), hiddenManager)
+			// Encodages de compression acceptes par le client et plage
+			// d'octets (optionnels)
+			var encodages []string
+			var plage *plageOctets
+			invalide := false
+			for _, arg := range parts[2:] {
+				if !strings.HasPrefix(arg, proto.PrefixePlage) {
+					encodages = strings.Split(arg, ",")
+					continue
+				}
+				p, err := lirePlage(strings.TrimPrefix(arg, proto.PrefixePlage))
+				if err != nil {
+					invalide = true
+					break
+				}
+				plage = &p
+			}
+			if invalide {
+				log.Warn("Invalid Get range", "args", parts[2:])
+				resultat := resultatInvalide
+				if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" invalid range\n"); err != nil {
+					log.Error("Failed to send Error", "error", err)
+					state.metriques.erreur(erreurEnvoi)
+					resultat = resultatErreur
+				}
+				state.metriques.commande(cmd, resultat)
+				continue
+			}
+			state.metriques.commande(cmd, commandGet(ctx, cnx, reader, writer, *courant, filename, encodages, plage, hiddenManager, state, journal))
+
+		case proto.CommandeHash:
+			if len(parts) < 2 {
+				log.Warn("Hash command missing filename")
+				state.metriques.commande(cmd, resultatInvalide)
+				continue
+			}
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandHash(ctx, writer, *courant, parts[1], hiddenManager, state))
+
+		case proto.CommandeUse:
+			state.metriques.commande(cmd, commandUse(ctx, writer, parts[1:], ip, &courant, state))
+
+		case proto.CommandeShares:
+			state.metriques.commande(cmd, commandShares(ctx, writer, ip, state))
+
+		case proto.CommandeWatch:
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandWatch(ctx, reader, writer, *courant, parts[1:], hiddenManager, state))
+			derniereCommande = time.Now()
+
+		case proto.CommandeFollow:
+			if len(parts) < 2 {
+				log.Warn("Follow command missing filename")
+				state.metriques.commande(cmd, resultatInvalide)
+				continue
+			}
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandFollow(ctx, reader, writer, *courant, parts[1:], hiddenManager, state, journal))
+			derniereCommande = time.Now()
+
+		case proto.CommandeDelete, proto.CommandeRename, proto.CommandeMkdir:
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandModifier(ctx, writer, *courant, cmd, parts[1:], false, hiddenManager, state, journal))
+
+		case proto.CommandePut:
+			if courant == nil {
+				state.metriques.commande(cmd, commandSansPartage(ctx, writer, state))
+				continue
+			}
+			state.metriques.commande(cmd, commandPut(ctx, reader, writer, *courant, parts[1:], ip, hiddenManager, state, journal))
 
 		case proto.CommandeEnd:
 			return
 
 		default:
-			slog.Warn("Unknown command", "command", cmd)
+			log.Warn("Unknown command", "command", cmd)
+			state.metriques.commande(commandeAutre, resultatInvalide)
+		}
+
+		state.bus.publier(evenement{genre: evInactif, session: id, client: journal.Client})
+	}
+}
+
+// attendrePlace obtient une place pour le client. S'il n'y en a pas, le client
+// attend dans la file en etant informe de sa position ("Queued <n>", puis "Ready"),
+// ou recoit "Busy" si la file est pleine.
+// Retourne false si le client ne doit pas etre servi.
+func attendrePlace(ctx context.Context, cnx net.Conn, writer *bufio.Writer, state *ServerState) bool {
+	log := logctx.From(ctx)
+	req := entrerRequest{response: make(chan entrerResponse)}
+	state.admission <- req
+	rep := <-req.response
+
+	if rep.admis {
+		return true
+	}
+
+	if rep.attente == nil {
+		log.Warn("Server busy, connection refused")
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseBusy+"\n"); err != nil {
+			log.Error("Failed to send Busy", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+		}
+		return false
+	}
+
+	// Quitter la file ; si une place a ete attribuee entre-temps, la rendre
+	abandonner := func() {
+		req := abandonRequest{attente: rep.attente, response: make(chan bool)}
+		state.admission <- req
+		if <-req.response {
+			state.admission <- sortirRequest{}
+		}
+	}
+
+	for {
+		select {
+		case <-rep.attente.admis:
+			log.Info("Client admitted from queue")
+			if err := sendrec.SendMessage(ctx, writer, proto.ReponseReady+"\n"); err != nil {
+				log.Error("Failed to send Ready", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				state.admission <- sortirRequest{}
+				return false
+			}
+			return true
+
+		case pos := <-rep.attente.position:
+			log.Info("Client waiting in queue", "position", pos)
+			msg := fmt.Sprintf("%s %d\n", proto.ReponseQueued, pos)
+			if err := sendrec.SendMessage(ctx, writer, msg); err != nil {
+				log.Error("Failed to send Queued", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				abandonner()
+				return false
+			}
+
+		case <-state.shutdown:
+			abandonner()
+			return false
 		}
 	}
 }
 
 // --- CLIENT DE CONTRÔLE ---
-func gererClientControle(cnx net.Conn, dir string, hiddenManager chan interface{}, state *ServerState) {
+func gererClientControle(ctx context.Context, id string, cnx net.Conn, hiddenManager chan interface{}, state *ServerState) {
+	log := logctx.From(ctx)
 	defer func() {
 		cnx.Close()
-		slog.Info("Control connection closed", "client", cnx.RemoteAddr().String())
+		log.Info("Control connection closed")
+	}()
+
+	// Les sessions de controle n'apparaissent dans le journal d'acces
+	// que si elles modifient un partage
+	journal := newSessionRecord(id, cnx.RemoteAddr().String())
+	defer func() {
+		if len(journal.Operations) == 0 {
+			return
+		}
+		journal.Disconnected = time.Now()
+		if err := state.accessLog.ecrire(journal); err != nil {
+			log.Error("Failed to write access log", "error", err)
+			state.metriques.erreur(erreurJournal)
+		}
 	}()
 
-	slog.Info("Control client connected", "client", cnx.RemoteAddr().String())
+	log.Info("Control client connected")
 
 	reader := bufio.NewReader(cnx)
 	writer := bufio.NewWriter(cnx)
 
+	// Le port de controle a acces a tous les partages
+	var courant *Share
+	if share, ok := state.config.Load().partage(partageParDefaut); ok {
+		courant = &share
+	}
+
 	for {
 		// Lire la commande
 		cmdLine, err := reader.ReadString('\n')
 		if err != nil {
-			slog.Error("Control connection error", "error", err)
+			log.Error("Control connection error", "error", err)
+			state.metriques.erreur(erreurConnexion)
 			return
 		}
 
@@ -148,364 +483,1806 @@ func gererClientControle(cnx net.Conn, dir string, hiddenManager chan interface{
 		parts := strings.Fields(cmdLine)
 		cmd := parts[0]
 
-		slog.Debug("Received control command",
+		log.Debug("Received control command",
 			"command", cmd,
 			"args", parts[1:],
-			"from", cnx.RemoteAddr().String(),
 		)
 
 		switch cmd {
 
 		case proto.CommandeList:
-			commandList(reader, writer, dir, hiddenManager)
+			state.metriques.commande(cmd, commandList(ctx, reader, writer, *courant, len(parts) >= 2 && parts[1] == proto.ListeDetaillee, hiddenManager, state))
 
 		case proto.CommandeHide:
 			if len(parts) < 2 {
-				slog.Warn("Hide command missing filename")
+				log.Warn("Hide command missing filename")
+				state.metriques.commande(cmd, resultatInvalide)
 				continue
 			}
 			filename := parts[1]
-			commandHide(writer, dir, filename, hiddenManager)
+			state.metriques.commande(cmd, commandHide(ctx, writer, *courant, filename, hiddenManager, state))
 
 		case proto.CommandeReveal:
 			if len(parts) < 2 {
-				slog.Warn("Reveal command missing filename")
+				log.Warn("Reveal command missing filename")
+				state.metriques.commande(cmd, resultatInvalide)
 				continue
 			}
 			filename := parts[1]
-			commandReveal(writer, dir, filename, hiddenManager)
+			state.metriques.commande(cmd, commandReveal(ctx, writer, *courant, filename, hiddenManager, state))
+
+		case proto.CommandeUse:
+			state.metriques.commande(cmd, commandUse(ctx, writer, parts[1:], netip.Addr{}, &courant, state))
+
+		case proto.CommandeDelete, proto.CommandeRename, proto.CommandeMkdir:
+			state.metriques.commande(cmd, commandModifier(ctx, writer, *courant, cmd, parts[1:], true, hiddenManager, state, journal))
+
+		case proto.CommandeShares:
+			state.metriques.commande(cmd, commandShares(ctx, writer, netip.Addr{}, state))
+
+		case proto.CommandeRate:
+			state.metriques.commande(cmd, commandRate(ctx, writer, parts[1:], state))
+
+		case proto.CommandeMaxClients:
+			state.metriques.commande(cmd, commandMaxClients(ctx, writer, parts[1:], state))
+
+		case proto.CommandeLogLevel:
+			state.metriques.commande(cmd, commandLogLevel(ctx, writer, parts[1:], state))
+
+		case proto.CommandeReloadAcl:
+			state.metriques.commande(cmd, commandReloadAcl(ctx, writer, state))
+
+		case proto.CommandeReload:
+			state.metriques.commande(cmd, commandReload(ctx, writer, hiddenManager, state))
+
+		case proto.CommandeRescan:
+			state.metriques.commande(cmd, commandRescan(ctx, writer, parts[1:], state))
+
+		case proto.CommandeQuota:
+			state.metriques.commande(cmd, commandQuota(ctx, writer, state))
 
 		case proto.CommandeTerminate:
-			commandTerminate(writer, state)
+			state.metriques.commande(cmd, commandTerminate(ctx, writer, state))
 			return
 
 		case proto.CommandeEnd:
 			return
 
 		default:
-			slog.Warn("Unknown control command", "command", cmd)
+			log.Warn("Unknown control command", "command", cmd)
+			state.metriques.commande(commandeAutre, resultatInvalide)
 		}
 	}
 }
 
 
 // --- COMMANDE LIST ---
-func commandList(reader *bufio.Reader, writer *bufio.Writer, dir string, hiddenManager chan interface{}) {
-	entries, err := os.ReadDir(dir)
+func commandList(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, share Share, detail bool, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+	stock, err := ouvrirStockage(state, share)
+	var infos []fs.FileInfo
+	if err == nil {
+		infos, err = stock.lister()
+	}
 	if err != nil {
-		slog.Error("Failed to read directory", "error", err)
+		log.Error("Failed to read directory", "share", share.Name, "error", err)
+		state.metriques.erreur(erreurFichier)
+		resultat = resultatErreur
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" cannot read share "+share.Name+"\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+		}
 		return
 	}
 
 	// Recup la liste des fichiers caches
-	req := listHiddenRequest{response: make(chan map[string]bool)}
+	req := listHiddenRequest{share: share.Name, response: make(chan map[string]bool)}
 	hiddenManager <- req
 	hiddenFiles := <-req.response
+	reqMotifs := hidePatternsRequest{response: make(chan []string)}
+	hiddenManager <- reqMotifs
+	motifs := <-reqMotifs.response
 
 	// Filtre pour ne garder que les fichiers non caches
-	var files []os.DirEntry
-	for _, e := range entries {
-		if !e.IsDir() && !hiddenFiles[e.Name()] {
-			files = append(files, e)
+	var files []fs.FileInfo
+	for _, info := range infos {
+		if !hiddenFiles[info.Name()] && !correspondMotif(motifs, info.Name()) && !correspondMotif(share.Hide, info.Name()) {
+			files = append(files, info)
 		}
 	}
 
 	// Envoie FileCnt
 	header := fmt.Sprintf("%s %d\n", proto.ReponseFileCount, len(files))
-	if err := sendrec.SendMessage(writer, header); err != nil {
-		slog.Error("Failed to send FileCnt", "error", err)
+	if err := sendrec.SendMessage(ctx, writer, header); err != nil {
+		log.Error("Failed to send FileCnt", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
 		return
 	}
 
-	// Envoie les noms + tailles
+	// Envoie les noms + tailles (+ dates si demandees)
 	for _, f := range files {
-		info, err := f.Info()
-		if err != nil {
-			slog.Warn("Could not stat file", "file", f.Name(), "error", err)
-			continue
+		line := fmt.Sprintf("%s %d\n", f.Name(), f.Size())
+		if detail {
+			line = fmt.Sprintf("%s %d %s\n", f.Name(), f.Size(), f.ModTime().UTC().Format(time.RFC3339Nano))
 		}
-
-		line := fmt.Sprintf("%s %d\n", f.Name(), info.Size())
-		if err := sendrec.SendMessage(writer, line); err != nil {
-			slog.Error("Failed to send file info", "file", f.Name(), "error", err)
+		if err := sendrec.SendMessage(ctx, writer, line); err != nil {
+			log.Error("Failed to send file info", "file", f.Name(), "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 			return
 		}
 	}
 
 	// Attendre le OK du client
-	resp, err := sendrec.ReceiveMessage(reader)
+	resp, err := sendrec.ReceiveMessage(ctx, reader)
 	if err != nil {
-		slog.Error("Error waiting for client OK", "error", err)
-		return
+		log.Error("Error waiting for client OK", "error", err)
+		state.metriques.erreur(erreurConnexion)
+		return resultatNonConfirme
 	}
 
 	if resp == proto.ReponseOk {
-		slog.Debug("Client confirmed reception of list")
-	} else {
-		slog.Warn("Client did not send OK", "received", resp)
+		log.Debug("Client confirmed reception of list")
+		return resultatOk
 	}
+	log.Warn("Client did not send OK", "received", resp)
+	return resultatNonConfirme
+}
+
+// plageOctets est la partie d'un fichier demandee par Get : de debut
+// (inclus) a fin (exclue).
+type plageOctets struct {
+	debut, fin int64
+}
+
+// lirePlage lit une plage "<debut>-<fin>".
+func lirePlage(s string) (plageOctets, error) {
+	d, f, ok := strings.Cut(s, "-")
+	if !ok {
+		return plageOctets{}, fmt.Errorf("invalid range %q", s)
+	}
+	debut, err := strconv.ParseInt(d, 10, 64)
+	if err != nil {
+		return plageOctets{}, err
+	}
+	fin, err := strconv.ParseInt(f, 10, 64)
+	if err != nil {
+		return plageOctets{}, err
+	}
+	if debut < 0 || fin < debut {
+		return plageOctets{}, fmt.Errorf("invalid range %q", s)
+	}
+	return plageOctets{debut: debut, fin: fin}, nil
 }
 
 // --- COMMANDE GET ---
-func commandGet(reader *bufio.Reader, writer *bufio.Writer, dir string, filename string, clientAddr string, hiddenManager chan interface{}) {
+func commandGet(ctx context.Context, cnx net.Conn, reader *bufio.Reader, writer *bufio.Writer, share Share, filename string, encodages []string, plage *plageOctets, hiddenManager chan interface{}, state *ServerState, journal *sessionRecord) (resultat string) {
+	log := logctx.From(ctx)
+	// Journal d'acces et metriques : le resultat est mis a jour au fil de la commande
+	debut := time.Now()
+	resultat = resultatInconnu
+	var encodage string
+	var totalSent, wireSent int64
+	defer func() {
+		journal.telechargement(filename, totalSent, debut, resultat, encodage)
+		if resultat == resultatOk || resultat == resultatNonConfirme {
+			state.metriques.transfert(wireSent, time.Since(debut))
+		}
+		state.bus.publier(evenement{genre: evTransfert, session: journal.Session, client: journal.Client,
+			fichier: filename, octets: totalSent, texte: fmt.Sprintf("Get %s: %s (%d bytes)", filename, resultat, totalSent)})
+	}()
+
 	// Verifie si le fichier est caché
-	req := isHiddenRequest{filename: filename, response: make(chan bool)}
+	req := isHiddenRequest{share: share.Name, filename: filename, response: make(chan bool)}
 	hiddenManager <- req
-	if <-req.response {
-		slog.Warn("Attempt to get hidden file", "file", filename, "client", clientAddr)
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+	if <-req.response || correspondMotif(share.Hide, filename) {
+		log.Warn("Attempt to get hidden file", "file", filename)
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 
-	// Construi le chemin complet du fichier
-	filepath := dir + "/" + filename
-
 	// Verifie si le fichier existe
-	fileInfo, err := os.Stat(filepath)
+	stock, err := ouvrirStockage(state, share)
+	var fileInfo fs.FileInfo
+	if err == nil {
+		fileInfo, err = stock.stat(filename)
+	}
 	if err != nil {
-		slog.Warn("File not found", "file", filename, "client", clientAddr)
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+		log.Warn("File not found", "file", filename)
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 
 	// Verifie que c'est bien un fichier
 	if fileInfo.IsDir() {
-		slog.Warn("Requested path is a directory", "path", filename, "client", clientAddr)
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+		log.Warn("Requested path is a directory", "path", filename)
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 
 	// Ouvrir le fichier
-	file, err := os.Open(filepath)
+	file, err := stock.ouvrir(filename)
 	if err != nil {
-		slog.Error("Failed to open file", "file", filename, "error", err)
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+		log.Error("Failed to open file", "file", filename, "error", err)
+		resultat = resultatErreur
+		state.metriques.erreur(erreurFichier)
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 	defer file.Close()
 
-	// Envoie "Start <size>"
-	startMsg := fmt.Sprintf("%s %d\n", proto.ReponseStart, fileInfo.Size())
-	if err := sendrec.SendMessage(writer, startMsg); err != nil {
-		slog.Error("Failed to send Start", "error", err)
-		return
-	}
-
-	slog.Debug("Sending file", "file", filename, "size", fileInfo.Size(), "client", clientAddr)
-
-	// Envoie le contenu du fichier (flux binaire)
-	buffer := make([]byte, 4096)
-	totalSent := int64(0)
-
-	for {
-		n, err := file.Read(buffer)
-		if n > 0 {
-			written, writeErr := writer.Write(buffer[:n])
-			if writeErr != nil {
-				slog.Error("Failed to write file data", "error", writeErr)
-				return
+	// Plage d'octets : seule cette partie du fichier est envoyee
+	taille := fileInfo.Size()
+	var contenu io.Reader = file
+	if plage != nil {
+		if !fileInfo.Mode().IsRegular() || plage.debut > taille {
+			log.Warn("Range outside file", "file", filename, "start", plage.debut, "end", plage.fin, "size", taille)
+			resultat = resultatInvalide
+			if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" range outside file\n"); err != nil {
+				log.Error("Failed to send Error", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				resultat = resultatErreur
 			}
-			totalSent += int64(written)
+			return
 		}
-
-		if err != nil {
-			if err == io.EOF {
-				break
+		if _, err := file.Seek(plage.debut, io.SeekStart); err != nil {
+			log.Error("Failed to seek file", "file", filename, "error", err)
+			state.metriques.erreur(erreurFichier)
+			resultat = resultatErreur
+			if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" cannot read "+filename+"\n"); err != nil {
+				log.Error("Failed to send Error", "error", err)
+				state.metriques.erreur(erreurEnvoi)
 			}
-			slog.Error("Error reading file", "error", err)
 			return
 		}
+		taille = min(plage.fin, taille) - plage.debut
+		contenu = io.LimitReader(file, taille)
+		// Une plage n'est jamais compressee
+		encodages = nil
 	}
 
-	// Flush
-	if err := writer.Flush(); err != nil {
-		slog.Error("Failed to flush file data", "error", err)
+	// Avancement du transfert pour les observateurs (tableau de bord)
+	total := taille
+	if !fileInfo.Mode().IsRegular() {
+		total = -1
+	}
+	suivi := &suiviProgression{progres: func(n int64) {
+		state.bus.publier(evenement{genre: evProgression, session: journal.Session, client: journal.Client,
+			fichier: filename, octets: n, total: total})
+	}}
+
+	// Un contenu compresse a une taille inconnue : il est toujours envoye par blocs
+	config := state.config.Load()
+	encodage = choisirEncodage(*config, filename, taille, encodages)
+
+	// Par blocs si la taille n'est pas connue a l'avance (fichier special)
+	// ou si le serveur l'impose
+	if encodage != "" || config.Chunked || !fileInfo.Mode().IsRegular() {
+		totalSent, wireSent, err = envoyerParBlocs(ctx, writer, contenu, encodage, suivi)
+	} else {
+		// Une limite de debit impose de passer par le writer
+		var direct net.Conn
+		if !state.debit.actif() {
+			direct = cnx
+		}
+		// file (deja positionne) plutot que contenu : CopyN limite deja a
+		// taille, et sendfile ne sait pas lire a travers deux LimitReader
+		totalSent, err = envoyerTailleFixe(ctx, direct, writer, file, taille, suivi)
+		wireSent = totalSent
+	}
+	if err != nil {
+		log.Error("Failed to send file", "file", filename, "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
 		return
 	}
 
-	slog.Debug("File sent successfully", "file", filename, "bytes", totalSent)
+	log.Debug("File sent successfully", "file", filename, "bytes", totalSent, "wire_bytes", wireSent)
 
 	// Attendre OK
-	resp, err := sendrec.ReceiveMessage(reader)
+	resultat = resultatNonConfirme
+	resp, err := sendrec.ReceiveMessage(ctx, reader)
 	if err != nil {
-		slog.Error("Error waiting for client OK", "error", err)
+		log.Error("Error waiting for client OK", "error", err)
+		state.metriques.erreur(erreurConnexion)
 		return
 	}
 
 	if resp == proto.ReponseOk {
-		slog.Info("File transferred successfully", "file", filename, "size", totalSent, "client", clientAddr)
+		resultat = resultatOk
+		if encodage != "" {
+			log.Info("File transferred successfully", "file", filename, "size", totalSent,
+				"encoding", encodage, "wire_size", wireSent, "ratio", ratioCompression(totalSent, wireSent))
+		} else {
+			log.Info("File transferred successfully", "file", filename, "size", totalSent)
+		}
+	} else if strings.HasPrefix(resp, proto.ReponseError) {
+		resultat = resultatRefuse
+		log.Error("Client rejected file transfer", "file", filename, "reason", strings.TrimSpace(strings.TrimPrefix(resp, proto.ReponseError)))
 	} else {
-		slog.Warn("Client did not send OK after file transfer", "received", resp, "file", filename)
+		log.Warn("Client did not send OK after file transfer", "received", resp, "file", filename)
+	}
+	return
+}
+
+// envoyerTailleFixe envoie "Start <size>" puis exactement size octets.
+// Si le fichier grandit pendant le transfert, les octets en trop ne sont pas envoyes.
+// Sur une connexion TCP brute, le contenu est confie directement au noyau
+// (sendfile sous Linux) sans passer par le tampon du writer.
+// direct peut etre nil pour forcer le passage par le writer.
+func envoyerTailleFixe(ctx context.Context, direct net.Conn, writer *bufio.Writer, file io.Reader, size int64, suivi *suiviProgression) (int64, error) {
+	log := logctx.From(ctx)
+	startMsg := fmt.Sprintf("%s %d\n", proto.ReponseStart, size)
+	if err := sendrec.SendMessage(ctx, writer, startMsg); err != nil {
+		return 0, err
+	}
+
+	// SendMessage a vide le tampon : on peut ecrire directement sur la connexion
+	if tcp, ok := direct.(*net.TCPConn); ok {
+		log.Debug("Sending file (zero-copy)", "size", size)
+		// Par tranches pour pouvoir suivre l'avancement
+		var totalSent int64
+		for totalSent < size {
+			n, err := io.CopyN(tcp, file, min(size-totalSent, trancheZeroCopie))
+			totalSent += n
+			suivi.signaler(totalSent)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return totalSent, err
+			}
+		}
+		return totalSent, nil
+	}
+
+	// Sinon (connexion enveloppee), copie via le tampon
+	log.Debug("Sending file", "size", size)
+	totalSent, err := io.CopyN(writer, &lecteurProgression{r: file, suivi: suivi}, size)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return totalSent, err
+	}
+	return totalSent, writer.Flush()
+}
+
+// envoyerParBlocs envoie "Start chunked [<encodage>]" puis le contenu jusqu'a EOF
+// sous forme de blocs, eventuellement compresse, suivi d'un trailer contenant
+// l'empreinte SHA-256 du contenu non compresse.
+// Retourne le nombre d'octets lus dans le fichier et le nombre d'octets envoyes.
+func envoyerParBlocs(ctx context.Context, writer *bufio.Writer, file io.Reader, encodage string, suivi *suiviProgression) (int64, int64, error) {
+	log := logctx.From(ctx)
+	startMsg := fmt.Sprintf("%s %s\n", proto.ReponseStart, proto.ModeChunked)
+	if encodage != "" {
+		startMsg = fmt.Sprintf("%s %s %s\n", proto.ReponseStart, proto.ModeChunked, encodage)
+	}
+	if err := sendrec.SendMessage(ctx, writer, startMsg); err != nil {
+		return 0, 0, err
 	}
+
+	log.Debug("Sending file in chunks", "encoding", encodage)
+
+	cw := sendrec.NewChunkedWriter(writer)
+	wire := &sendrec.CountingWriter{W: cw}
+	var dst io.Writer = wire
+	var zw io.WriteCloser
+	if encodage != "" {
+		var err error
+		zw, err = sendrec.NewCompressor(encodage, wire)
+		if err != nil {
+			return 0, 0, err
+		}
+		dst = zw
+	}
+
+	h := sha256.New()
+	totalSent, err := io.Copy(io.MultiWriter(dst, h), &lecteurProgression{r: file, suivi: suivi})
+	if err != nil {
+		return totalSent, wire.N, err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return totalSent, wire.N, err
+		}
+	}
+	cw.Trailer(proto.TrailerSha256, hex.EncodeToString(h.Sum(nil)))
+	return totalSent, wire.N, cw.Close()
+}
+
+// ratioCompression formate le rapport taille d'origine / taille envoyee.
+func ratioCompression(raw, wire int64) string {
+	if wire == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(raw)/float64(wire), 'f', 2, 64)
+}
+
+// --- COMMANDE HASH ---
+// Taille et empreinte SHA-256 d'un fichier : "Hash <taille> <sha256>".
+// Permet de verifier un fichier telecharge par plages. Les empreintes sont
+// gardees en cache tant que le fichier ne change pas (taille et date).
+func commandHash(ctx context.Context, writer *bufio.Writer, share Share, filename string, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	repondre := func(res, reponse string) string {
+		if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+			log.Error("Failed to send response", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+		return res
+	}
+
+	// Un fichier cache est presente comme inconnu
+	req := isHiddenRequest{share: share.Name, filename: filename, response: make(chan bool)}
+	hiddenManager <- req
+	if <-req.response || correspondMotif(share.Hide, filename) {
+		log.Warn("Attempt to hash hidden file", "file", filename)
+		return repondre(resultatInconnu, proto.ReponseFileUnknown)
+	}
+
+	stock, err := ouvrirStockage(state, share)
+	var info fs.FileInfo
+	if err == nil {
+		info, err = stock.stat(filename)
+	}
+	if err != nil || info.IsDir() {
+		log.Warn("File not found", "file", filename)
+		return repondre(resultatInconnu, proto.ReponseFileUnknown)
+	}
+	if !info.Mode().IsRegular() {
+		log.Warn("Hash of a special file", "file", filename)
+		return repondre(resultatInvalide, proto.ReponseError+" "+filename+" is not a regular file")
+	}
+
+	cle := share.Backend + ":" + share.Path + ":" + filename
+	taille, empreinte, err := state.empreintes.calculer(cle, info, func() (io.ReadSeekCloser, error) {
+		return stock.ouvrir(filename)
+	})
+	if err != nil {
+		log.Error("Failed to hash file", "file", filename, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot read "+filename)
+	}
+	log.Debug("File hashed", "file", filename, "size", taille)
+	return repondre(resultatOk, fmt.Sprintf("%s %d %s", proto.ReponseHash, taille, empreinte))
 }
 
 // --- COMMANDE HIDE ---
-func commandHide(writer *bufio.Writer, dir string, filename string, hiddenManager chan interface{}) {
-	// Verifie que le fichier existe dans le dossier
-	filepath := dir + "/" + filename
-	fileInfo, err := os.Stat(filepath)
+func commandHide(ctx context.Context, writer *bufio.Writer, share Share, filename string, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	// Verifie que le fichier existe dans le partage
+	stock, err := ouvrirStockage(state, share)
+	var fileInfo fs.FileInfo
+	if err == nil {
+		fileInfo, err = stock.stat(filename)
+	}
 	if err != nil || fileInfo.IsDir() {
-		slog.Warn("Cannot hide file", "file", filename, "reason", "not found or is directory")
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+		log.Warn("Cannot hide file", "file", filename, "reason", "not found or is directory")
+		resultat = resultatInconnu
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 
 	// Cacher le fichier via le canal
-	req := hideRequest{filename: filename, response: make(chan bool)}
+	req := hideRequest{share: share.Name, filename: filename, response: make(chan bool)}
 	hiddenManager <- req
 	<-req.response
 
-	slog.Info("File hidden", "file", filename)
+	log.Info("File hidden", "share", share.Name, "file", filename)
+	ev := changementFichier(proto.EvenementCache, filename)
+	ev.partage = share.Name
+	state.bus.publier(ev)
 
 	// Confirme
-	if err := sendrec.SendMessage(writer, proto.ReponseOk+"\n"); err != nil {
-		slog.Error("Failed to send OK", "error", err)
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
 	}
+	return
 }
 
 // --- COMMANDE REVEAL ---
-func commandReveal(writer *bufio.Writer, dir string, filename string, hiddenManager chan interface{}) {
-	// Verfie que le fichier existe dans le dossier
-	filepath := dir + "/" + filename
-	fileInfo, err := os.Stat(filepath)
+func commandReveal(ctx context.Context, writer *bufio.Writer, share Share, filename string, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	// Verfie que le fichier existe dans le partage
+	stock, err := ouvrirStockage(state, share)
+	var fileInfo fs.FileInfo
+	if err == nil {
+		fileInfo, err = stock.stat(filename)
+	}
 	if err != nil || fileInfo.IsDir() {
-		slog.Warn("Cannot reveal file", "file", filename, "reason", "not found or is directory")
-		if err := sendrec.SendMessage(writer, proto.ReponseFileUnknown+"\n"); err != nil {
-			slog.Error("Failed to send FileUnknown", "error", err)
+		log.Warn("Cannot reveal file", "file", filename, "reason", "not found or is directory")
+		resultat = resultatInconnu
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseFileUnknown+"\n"); err != nil {
+			log.Error("Failed to send FileUnknown", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
 		}
 		return
 	}
 
 	// Revele le fichier via le canal
-	req := revealRequest{filename: filename, response: make(chan bool)}
+	req := revealRequest{share: share.Name, filename: filename, response: make(chan bool)}
 	hiddenManager <- req
 	wasHidden := <-req.response
 
 	if wasHidden {
-		slog.Info("File revealed", "file", filename)
+		log.Info("File revealed", "share", share.Name, "file", filename)
+		ev := changementFichier(proto.EvenementRevele, filename)
+		ev.partage = share.Name
+		state.bus.publier(ev)
 	} else {
-		slog.Debug("File was not hidden", "file", filename)
+		log.Debug("File was not hidden", "file", filename)
 	}
 
 	// Confirmer
-	if err := sendrec.SendMessage(writer, proto.ReponseOk+"\n"); err != nil {
-		slog.Error("Failed to send OK", "error", err)
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
 	}
+	return
 }
 
-func commandTerminate(writer *bufio.Writer, state *ServerState) {
-	slog.Info("Terminate command received - initiating server shutdown")
-
-	// Signale l'arret a tous les clients
-	close(state.shutdown)
-
-	// Laisser un temps au client pour recevoir le signal
-	time.Sleep(100 * time.Millisecond)
-
-	// Attend les clients qui se deconnectent
-	slog.Info("Waiting for all clients to disconnect...")
-	
-	// Timeout de 5s qui evite de bloquer indefiniment
-	done := make(chan struct{})
-	go func() {
-		state.wg.Wait()
-		close(done)
+// --- COMMANDES DELETE, RENAME, MKDIR ---
+// "Delete <nom>" supprime un fichier ou un dossier vide, "Rename <ancien>
+// <nouveau>" renomme sans remplacer, "Mkdir <dossier>" cree un dossier.
+// Sur le port principal, le partage doit etre modifiable (Writable) ; le port
+// de controle (controle = true) peut modifier tous les dossiers.
+// Chaque operation est atomique pour les List et Get concurrents : un Get en
+// cours sur un fichier supprime ou renomme se termine avec le fichier deja
+// ouvert. Rename verifie que la destination n'existe pas puis renomme, sous
+// le verrou state.renommages que prennent aussi Delete, Mkdir et la fin des Put.
+// Les fichiers caches sont traites comme absents, et les fichiers
+// temporaires des envois en cours sont refuses.
+func commandModifier(ctx context.Context, writer *bufio.Writer, share Share, cmd string, args []string, controle bool, hiddenManager chan interface{}, state *ServerState, journal *sessionRecord) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	nom, cible := "", ""
+	if len(args) > 0 {
+		nom = args[0]
+	}
+	if cmd == proto.CommandeRename && len(args) > 1 {
+		cible = args[1]
+	}
+	defer func() {
+		journal.operation(cmd, share.Name, nom, cible, 0, resultat)
 	}()
 
-	select {
-	case <-done:
-		slog.Info("All clients disconnected")
-	case <-time.After(5 * time.Second):
-		slog.Warn("Timeout waiting for clients to disconnect, forcing shutdown")
+	repondre := func(res, reponse string) string {
+		if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+			log.Error("Failed to send response", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+		return res
 	}
 
-	// Confirmer
-	if err := sendrec.SendMessage(writer, proto.ReponseOk+"\n"); err != nil {
-		slog.Error("Failed to send OK", "error", err)
+	attendus := 1
+	usage := "usage: " + cmd + " <name>"
+	if cmd == proto.CommandeRename {
+		attendus = 2
+		usage = "usage: Rename <old> <new>"
+	}
+	if len(args) != attendus {
+		log.Warn("Invalid command arguments", "command", cmd, "args", args)
+		return repondre(resultatInvalide, proto.ReponseError+" "+usage)
 	}
 
-	slog.Info("Server shutdown complete")
-}
+	if !controle && !share.Writable {
+		log.Warn("Modification of a read-only share", "command", cmd, "share", share.Name)
+		return repondre(resultatInvalide, proto.ReponseError+" share "+share.Name+" is read-only")
+	}
+	stock, err := ouvrirStockage(state, share)
+	if err != nil {
+		log.Error("Failed to read directory", "share", share.Name, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot read share "+share.Name)
+	}
+	local, ok := stock.(stockageLocal)
+	if !ok {
+		log.Warn("Modification of an archive share", "command", cmd, "share", share.Name)
+		return repondre(resultatInvalide, proto.ReponseError+" share "+share.Name+" is read-only")
+	}
 
-func RunServer(port *string, controlPort *string, dir *string) {
+	cache := func(name string) bool {
+		req := isHiddenRequest{share: share.Name, filename: name, response: make(chan bool)}
+		hiddenManager <- req
+		return <-req.response || correspondMotif(share.Hide, name)
+	}
+	chemin := func(name string) (string, bool) {
+		if name == "." {
+			return "", false
+		}
+		c, err := local.chemin(cmd, name)
+		return c, err == nil
+	}
 
-	nbClients := make(chan int)
-	hiddenManager := make(chan interface{})
-	state := &ServerState{
-		shutdown: make(chan struct{}),
-	}	
+	source, ok := chemin(nom)
+	if !ok {
+		log.Warn("Invalid file name", "command", cmd, "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" invalid name "+nom)
+	}
+	var taille int64
 
-	// Compteur clients
-	go func() {
-		nb := 0
-		for c := range nbClients {
-			nb += c
-			slog.Info("Clients connectés", slog.Int("count", nb))
+	switch cmd {
+	case proto.CommandeDelete:
+		if cache(nom) {
+			log.Warn("Attempt to delete hidden file", "file", nom)
+			return repondre(resultatInconnu, proto.ReponseFileUnknown)
+		}
+		// Taille liberee, pour l'utilisation du partage (quotas) : un Put ne
+		// peut pas remplacer le fichier entre la mesure et la suppression
+		state.renommages.Lock()
+		if info, e := os.Lstat(source); e == nil && info.Mode().IsRegular() {
+			taille = info.Size()
+		}
+		err = os.Remove(source)
+		state.renommages.Unlock()
+
+	case proto.CommandeRename:
+		destination, ok := chemin(cible)
+		if !ok {
+			log.Warn("Invalid file name", "command", cmd, "file", cible)
+			return repondre(resultatInvalide, proto.ReponseError+" invalid name "+cible)
+		}
+		if cache(nom) {
+			log.Warn("Attempt to rename hidden file", "file", nom)
+			return repondre(resultatInconnu, proto.ReponseFileUnknown)
+		}
+		if cache(cible) {
+			log.Warn("Rename to a hidden name", "file", nom, "target", cible)
+			return repondre(resultatInvalide, proto.ReponseError+" name not allowed: "+cible)
+		}
+		// os.Rename remplacerait la destination : elle ne doit pas exister
+		existe := false
+		state.renommages.Lock()
+		if _, err = os.Lstat(source); err == nil {
+			if _, e := os.Lstat(destination); e == nil {
+				existe = true
+			} else {
+				err = os.Rename(source, destination)
+			}
+		}
+		state.renommages.Unlock()
+		if existe {
+			log.Warn("Rename target exists", "file", nom, "target", cible)
+			return repondre(resultatInvalide, proto.ReponseError+" "+cible+" already exists")
 		}
-	}()
-
-	// Gestionnaire des fichiers caches
-	go func() {
-		hiddenFiles := make(map[string]bool)
-		for req := range hiddenManager {
-			switch r := req.(type) {
-			case hideRequest:
-				hiddenFiles[r.filename] = true
-				r.response <- true
 
-			case revealRequest:
-				wasHidden := hiddenFiles[r.filename]
-				delete(hiddenFiles, r.filename)
-				r.response <- wasHidden
+	case proto.CommandeMkdir:
+		if cache(nom) {
+			log.Warn("Mkdir with a hidden name", "dir", nom)
+			return repondre(resultatInvalide, proto.ReponseError+" name not allowed: "+nom)
+		}
+		state.renommages.Lock()
+		err = os.Mkdir(source, 0o755)
+		state.renommages.Unlock()
+	}
 
-			case isHiddenRequest:
-				r.response <- hiddenFiles[r.filename]
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist) && cmd != proto.CommandeMkdir:
+		log.Warn("File not found", "command", cmd, "file", nom)
+		return repondre(resultatInconnu, proto.ReponseFileUnknown)
+	case errors.Is(err, fs.ErrExist) && cmd == proto.CommandeMkdir:
+		log.Warn("File already exists", "command", cmd, "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" "+nom+" already exists")
+	default:
+		log.Error("File operation failed", "command", cmd, "file", nom, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" "+cmd+" failed: "+messageErreur(err))
+	}
 
-			case listHiddenRequest:
-				copy := make(map[string]bool)
-				for k, v := range hiddenFiles {
-					copy[k] = v
-				}
-				r.response <- copy
-			}
+	log.Info("Share modified", "command", cmd, "share", share.Name, "file", nom, "target", cible)
+	switch cmd {
+	case proto.CommandeDelete:
+		state.quotas.supprime(share.Name, nom, taille)
+	case proto.CommandeRename:
+		state.quotas.renomme(share.Name, nom, cible)
+	}
+	// List (et Watch) voient le changement sans attendre la verification du cache
+	if state.listes != nil {
+		if _, err := state.listes.rescanner(share.Path); err != nil {
+			log.Warn("Rescan after modification failed", "dir", share.Path, "error", err)
 		}
-	}()
+	}
+	return repondre(resultatOk, proto.ReponseOk)
+}
 
-	// Ecoute reseau principal
-	l, e := net.Listen("tcp", ":"+*port)
-	if e != nil {
-		slog.Error(e.Error())
-		return
+// --- COMMANDE PUT ---
+// "Put <nom> <taille>" envoie un fichier dans un partage modifiable. Le serveur
+// verifie la taille maximale et les quotas avant de repondre OK, puis recoit
+// le contenu par blocs dans un fichier temporaire, renomme a la fin : un
+// fichier remplace reste lisible jusqu'au bout par les Get en cours.
+// Le contenu ne peut pas depasser la taille annoncee (reservee dans les quotas) :
+// au-dela, la suite est lue sans etre ecrite et l'envoi est refuse.
+func commandPut(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, share Share, args []string, ip netip.Addr, hiddenManager chan interface{}, state *ServerState, journal *sessionRecord) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	nom := ""
+	if len(args) > 0 {
+		nom = args[0]
 	}
+	var recus int64
 	defer func() {
-		l.Close()
-		slog.Debug("Stopped listening on port " + *port)
+		journal.operation(proto.CommandePut, share.Name, nom, "", recus, resultat)
 	}()
 
-	// Ecoute reseau controle
-	lControl, e := net.Listen("tcp", ":"+*controlPort)
-	if e != nil {
+	repondre := func(res, reponse string) string {
+		if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+			log.Error("Failed to send response", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+		return res
+	}
+
+	if len(args) != 2 {
+		log.Warn("Invalid command arguments", "command", proto.CommandePut, "args", args)
+		return repondre(resultatInvalide, proto.ReponseError+" usage: Put <name> <size>")
+	}
+	taille, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || taille < 0 {
+		log.Warn("Invalid Put size", "size", args[1])
+		return repondre(resultatInvalide, proto.ReponseError+" invalid size "+args[1])
+	}
+
+	if !share.Writable {
+		log.Warn("Put to a read-only share", "share", share.Name)
+		return repondre(resultatInvalide, proto.ReponseError+" share "+share.Name+" is read-only")
+	}
+	stock, err := ouvrirStockage(state, share)
+	if err != nil {
+		log.Error("Failed to read directory", "share", share.Name, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot read share "+share.Name)
+	}
+	local, ok := stock.(stockageLocal)
+	if !ok {
+		log.Warn("Put to an archive share", "share", share.Name)
+		return repondre(resultatInvalide, proto.ReponseError+" share "+share.Name+" is read-only")
+	}
+	chemin, err := local.chemin("put", nom)
+	if err != nil || nom == "." {
+		log.Warn("Invalid file name", "command", proto.CommandePut, "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" invalid name "+nom)
+	}
+	req := isHiddenRequest{share: share.Name, filename: nom, response: make(chan bool)}
+	hiddenManager <- req
+	if <-req.response || correspondMotif(share.Hide, nom) {
+		log.Warn("Put with a hidden name", "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" name not allowed: "+nom)
+	}
+	if info, err := os.Stat(chemin); err == nil && info.IsDir() {
+		log.Warn("Put over a directory", "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" "+nom+" is a directory")
+	}
+
+	// Taille maximale et quotas, verifies avant d'accepter le contenu
+	res, err := state.quotas.reserver(state.config.Load(), share, ip.String(), nom, chemin, taille)
+	var quota *erreurQuota
+	if errors.As(err, &quota) {
+		log.Warn("Put refused", "file", nom, "size", taille, "reason", quota.Error())
+		return repondre(resultatQuota, fmt.Sprintf("%s %s %d", proto.ReponseQuotaExceeded, quota.portee, quota.limite))
+	}
+	if err != nil {
+		log.Error("Failed to measure share usage", "share", share.Name, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot read share "+share.Name)
+	}
+	reussi := false
+	var remplace int64
+	defer func() {
+		state.quotas.liberer(res, nom, recus, remplace, reussi)
+	}()
+
+	// Fichier temporaire dans le meme dossier : le renommage final est atomique
+	tmp, err := os.CreateTemp(filepath.Dir(chemin), prefixeEnvoi+"*")
+	if err != nil {
+		log.Error("Failed to create file", "file", nom, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot create "+nom+": "+messageErreur(err))
+	}
+	defer func() {
+		if !reussi {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		return resultatErreur
+	}
+
+	// Reception : au plus la taille annoncee est ecrite, le reste est lu pour
+	// garder la connexion utilisable
+	cr := sendrec.NewChunkedReader(reader)
+	h := sha256.New()
+	recus, err = io.Copy(io.MultiWriter(tmp, h), io.LimitReader(cr, taille))
+	var enTrop int64
+	if err == nil {
+		enTrop, err = io.Copy(io.Discard, cr)
+		recus += enTrop
+	}
+	if err != nil {
+		log.Error("Failed to receive file", "file", nom, "received", recus, "error", err)
+		state.metriques.erreur(erreurConnexion)
+		return resultatErreur
+	}
+
+	switch {
+	case enTrop > 0:
+		log.Warn("Put content larger than declared", "file", nom, "declared", taille, "received", recus)
+		return repondre(resultatQuota, fmt.Sprintf("%s content larger than declared size %d", proto.ReponseError, taille))
+	case recus < taille:
+		log.Warn("Put content smaller than declared", "file", nom, "declared", taille, "received", recus)
+		return repondre(resultatInvalide, fmt.Sprintf("%s content smaller than declared size %d", proto.ReponseError, taille))
+	}
+	if attendu, ok := cr.Trailers()[proto.TrailerSha256]; ok && attendu != hex.EncodeToString(h.Sum(nil)) {
+		log.Warn("Put checksum mismatch", "file", nom)
+		return repondre(resultatInvalide, proto.ReponseError+" checksum mismatch")
+	}
+
+	err = tmp.Chmod(0o644)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		// Taille du fichier remplace, mesuree sous le verrou : aucun autre
+		// envoi ne peut le remplacer entre-temps
+		state.renommages.Lock()
+		if info, e := os.Lstat(chemin); e == nil && info.Mode().IsRegular() {
+			remplace = info.Size()
+		}
+		err = os.Rename(tmp.Name(), chemin)
+		state.renommages.Unlock()
+	}
+	if err != nil {
+		log.Error("Failed to write file", "file", nom, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot write "+nom+": "+messageErreur(err))
+	}
+	reussi = true
+
+	log.Info("File received", "share", share.Name, "file", nom, "size", recus)
+	if state.listes != nil {
+		if _, err := state.listes.rescanner(share.Path); err != nil {
+			log.Warn("Rescan after modification failed", "dir", share.Path, "error", err)
+		}
+	}
+	return repondre(resultatOk, proto.ReponseOk)
+}
+
+// --- COMMANDE QUOTA ---
+// Utilisation des partages (dossiers) et des utilisateurs, avec leur limite
+// (0 = illimite) : "QuotaCnt <n>" puis "share|user <nom> <utilise> <limite>".
+func commandQuota(ctx context.Context, writer *bufio.Writer, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+	config := state.config.Load()
+
+	var lignes []string
+	for _, s := range config.partages() {
+		if info, err := os.Stat(s.Path); err != nil || !info.IsDir() {
+			continue
+		}
+		utilise, err := state.quotas.utilisation(s)
+		if err != nil {
+			log.Warn("Failed to measure share usage", "share", s.Name, "error", err)
+			state.metriques.erreur(erreurFichier)
+			continue
+		}
+		lignes = append(lignes, fmt.Sprintf("share %s %d %d", s.Name, utilise, s.Quota))
+	}
+	utilisateurs := state.quotas.utilisateurs()
+	adresses := make([]string, 0, len(utilisateurs))
+	for u := range utilisateurs {
+		adresses = append(adresses, u)
+	}
+	sort.Strings(adresses)
+	for _, u := range adresses {
+		lignes = append(lignes, fmt.Sprintf("user %s %d %d", u, utilisateurs[u], config.UserQuota))
+	}
+
+	header := fmt.Sprintf("%s %d\n", proto.ReponseQuotaCount, len(lignes))
+	if err := sendrec.SendMessage(ctx, writer, header); err != nil {
+		log.Error("Failed to send QuotaCnt", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		return resultatErreur
+	}
+	for _, l := range lignes {
+		if err := sendrec.SendMessage(ctx, writer, l+"\n"); err != nil {
+			log.Error("Failed to send quota info", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+	}
+	return
+}
+
+// messageErreur retourne la cause d'une erreur de fichier, sans le chemin
+// sur le serveur.
+func messageErreur(err error) string {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		return pathErr.Err.Error()
+	case errors.As(err, &linkErr):
+		return linkErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// --- COMMANDE WATCH ---
+// "Watch [<motif>]" : le serveur repond OK, puis envoie une ligne par changement
+// dans le partage ("Added <nom>", "Removed", "Modified", "Hidden", "Revealed"),
+// limitee aux fichiers correspondant au motif. Si des changements sont perdus
+// (client trop lent), le serveur envoie "Resync" : le client doit relire la
+// liste. Le client termine avec "Stop", auquel le serveur repond OK.
+// Les changements du dossier sont ceux detectes par le cache des listes
+// (cacheListes), donc visibles au plus tard apres son intervalle de verification.
+func commandWatch(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, share Share, args []string, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	refuser := func(res, msg string) string {
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" "+msg+"\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+		return res
+	}
+
+	motif := ""
+	if len(args) > 0 {
+		motif = args[0]
+		if _, err := filepath.Match(motif, ""); err != nil {
+			log.Warn("Invalid Watch pattern", "pattern", motif)
+			return refuser(resultatInvalide, "invalid pattern "+motif)
+		}
+	}
+
+	// Un dossier est surveille par le cache des listes ; une archive ne
+	// donne que les fichiers caches et reveles
+	if info, err := os.Stat(share.Path); err == nil && info.IsDir() {
+		if state.listes == nil {
+			log.Warn("Watch refused, listing cache disabled")
+			return refuser(resultatInvalide, "watch requires the listing cache (-listing-poll)")
+		}
+		liberer, err := state.listes.observer(share.Path)
+		if err != nil {
+			log.Error("Failed to read directory", "share", share.Name, "error", err)
+			state.metriques.erreur(erreurFichier)
+			return refuser(resultatErreur, "cannot read share "+share.Name)
+		}
+		defer liberer()
+	}
+
+	// Abonnement avant le OK : aucun changement n'est perdu ensuite, sauf
+	// si le client lit trop lentement (le serveur envoie alors Resync)
+	abonnement := state.bus.abonner(evFichier)
+	defer state.bus.desabonner(abonnement)
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		return resultatErreur
+	}
+	log.Info("Watching share", "share", share.Name, "pattern", motif)
+
+	// Lecture de la prochaine ligne du client pendant l'envoi des evenements
+	lignes := lireLigne(reader)
+
+	for {
+		select {
+		case <-state.shutdown:
+			log.Debug("Watch interrupted by server shutdown")
+			return
+
+		case l := <-lignes:
+			if l.err != nil {
+				log.Error("Connection error during Watch", "error", l.err)
+				state.metriques.erreur(erreurConnexion)
+				return resultatNonConfirme
+			}
+			if l.texte != proto.CommandeStop {
+				log.Warn("Unexpected command during Watch", "command", l.texte)
+				if res := refuser(resultatOk, "watch in progress, send Stop to end it"); res != resultatOk {
+					return res
+				}
+				lignes = lireLigne(reader)
+				continue
+			}
+			log.Info("Watch stopped", "share", share.Name)
+			if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+				log.Error("Failed to send OK", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				resultat = resultatErreur
+			}
+			return
+
+		case <-abonnement.pertes:
+			log.Warn("Watch events lost, client must resync", "share", share.Name)
+			if err := sendrec.SendMessage(ctx, writer, proto.EvenementResync+"\n"); err != nil {
+				log.Error("Failed to send Resync", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				return resultatErreur
+			}
+
+		case ev := <-abonnement.evenements:
+			if ev.partage != share.Name && ev.dossier != share.Path {
+				continue
+			}
+			if motif != "" {
+				if ok, _ := filepath.Match(motif, ev.fichier); !ok {
+					continue
+				}
+			}
+			// Les fichiers caches n'apparaissent pas dans les changements du dossier
+			if ev.dossier != "" {
+				req := isHiddenRequest{share: share.Name, filename: ev.fichier, response: make(chan bool)}
+				hiddenManager <- req
+				if <-req.response || correspondMotif(share.Hide, ev.fichier) {
+					continue
+				}
+			}
+			if err := sendrec.SendMessage(ctx, writer, ev.action+" "+ev.fichier+"\n"); err != nil {
+				log.Error("Failed to send Watch event", "error", err)
+				state.metriques.erreur(erreurEnvoi)
+				return resultatErreur
+			}
+		}
+	}
+}
+
+// ligneLue est une ligne du client lue pendant un envoi continu (Watch, Follow).
+type ligneLue struct {
+	texte string
+	err   error
+}
+
+// lireLigne lit la prochaine ligne du client dans une goroutine.
+func lireLigne(reader *bufio.Reader) chan ligneLue {
+	lignes := make(chan ligneLue, 1)
+	go func() {
+		texte, err := reader.ReadString('\n')
+		lignes <- ligneLue{strings.TrimSpace(texte), err}
+	}()
+	return lignes
+}
+
+// --- COMMANDE FOLLOW ---
+// "Follow <fichier> [<n>]" envoie le fichier (ou ses n dernieres lignes) par
+// blocs, puis ce qui y est ajoute, jusqu'a ce que le client envoie "Stop".
+// Le fichier est relu depuis le debut s'il est tronque ou remplace (rotation).
+// Seuls les fichiers d'un dossier peuvent etre suivis.
+func commandFollow(ctx context.Context, reader *bufio.Reader, writer *bufio.Writer, share Share, args []string, hiddenManager chan interface{}, state *ServerState, journal *sessionRecord) (resultat string) {
+	log := logctx.From(ctx)
+	filename := args[0]
+	debut := time.Now()
+	resultat = resultatInconnu
+	var totalSent int64
+	defer func() {
+		journal.telechargement(filename, totalSent, debut, resultat, "")
+		state.bus.publier(evenement{genre: evTransfert, session: journal.Session, client: journal.Client,
+			fichier: filename, octets: totalSent, texte: fmt.Sprintf("Follow %s: %s (%d bytes)", filename, resultat, totalSent)})
+	}()
+
+	repondre := func(res, reponse string) string {
+		if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+			log.Error("Failed to send response", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+		return res
+	}
+
+	lignesFin := -1
+	if len(args) >= 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			log.Warn("Invalid Follow line count", "lines", args[1])
+			return repondre(resultatInvalide, proto.ReponseError+" invalid line count "+args[1])
+		}
+		lignesFin = n
+	}
+
+	// Verifie si le fichier est caché
+	req := isHiddenRequest{share: share.Name, filename: filename, response: make(chan bool)}
+	hiddenManager <- req
+	if <-req.response || correspondMotif(share.Hide, filename) {
+		log.Warn("Attempt to follow hidden file", "file", filename)
+		return repondre(resultatInconnu, proto.ReponseFileUnknown)
+	}
+
+	stock, err := ouvrirStockage(state, share)
+	if err != nil {
+		log.Error("Failed to read directory", "share", share.Name, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseError+" cannot read share "+share.Name)
+	}
+	local, ok := stock.(stockageLocal)
+	if !ok {
+		log.Warn("Follow on a share that is not a directory", "share", share.Name)
+		return repondre(resultatInvalide, proto.ReponseError+" follow is only available on directory shares")
+	}
+	chemin, err := local.chemin("open", filename)
+	var info fs.FileInfo
+	if err == nil {
+		info, err = os.Stat(chemin)
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		log.Warn("File not found", "file", filename)
+		return repondre(resultatInconnu, proto.ReponseFileUnknown)
+	}
+
+	suivi, err := ouvrirSuivi(chemin)
+	if err == nil {
+		err = suivi.placer(lignesFin)
+	}
+	if err != nil {
+		log.Error("Failed to open file", "file", filename, "error", err)
+		state.metriques.erreur(erreurFichier)
+		return repondre(resultatErreur, proto.ReponseFileUnknown)
+	}
+	defer suivi.Close()
+
+	if err := sendrec.SendMessage(ctx, writer, fmt.Sprintf("%s %s\n", proto.ReponseStart, proto.ModeChunked)); err != nil {
+		log.Error("Failed to send Start", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		return resultatErreur
+	}
+	log.Info("Following file", "file", filename, "lines", lignesFin)
+
+	cw := sendrec.NewChunkedWriter(writer)
+	source := &lecteurProgression{r: suivi, suivi: &suiviProgression{progres: func(n int64) {
+		state.bus.publier(evenement{genre: evProgression, session: journal.Session, client: journal.Client,
+			fichier: filename, octets: n, total: -1})
+	}}}
+	ticker := time.NewTicker(intervalleSuivi)
+	defer ticker.Stop()
+	lignes := lireLigne(reader)
+
+	for {
+		// Envoie ce qui a ete ajoute depuis la derniere verification
+		n, err := io.Copy(cw, source)
+		totalSent += n
+		if err == nil {
+			err = cw.Flush()
+		}
+		if err != nil {
+			log.Error("Failed to send file", "file", filename, "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			return resultatErreur
+		}
+
+		select {
+		case <-ticker.C:
+			suivi.verifier(log)
+			continue
+
+		case <-state.shutdown:
+			log.Debug("Follow interrupted by server shutdown")
+			resultat = resultatNonConfirme
+
+		case l := <-lignes:
+			if l.err != nil {
+				log.Error("Connection error during Follow", "error", l.err)
+				state.metriques.erreur(erreurConnexion)
+				return resultatNonConfirme
+			}
+			if l.texte != proto.CommandeStop {
+				// Une reponse couperait le flux : la ligne est ignoree
+				log.Warn("Unexpected command during Follow", "command", l.texte)
+				lignes = lireLigne(reader)
+				continue
+			}
+			log.Info("Follow stopped", "file", filename, "size", totalSent)
+			resultat = resultatOk
+		}
+
+		if err := cw.Close(); err != nil {
+			log.Error("Failed to end chunked stream", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+}
+
+// --- COMMANDE USE ---
+// "Use <partage>" change le partage de la session.
+func commandUse(ctx context.Context, writer *bufio.Writer, args []string, ip netip.Addr, courant **Share, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	if len(args) != 1 {
+		log.Warn("Invalid Use command", "args", args)
+		resultat = resultatInvalide
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" usage: Use <share>\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	// Un partage interdit au client est presente comme inconnu
+	share, ok := state.config.Load().partage(args[0])
+	if !ok || !share.autorise(ip) {
+		log.Warn("Unknown or forbidden share", "share", args[0])
+		resultat = resultatInconnu
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" unknown share "+args[0]+"\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	*courant = &share
+	log.Info("Share selected", "share", share.Name)
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE SHARES ---
+// Liste les partages accessibles au client : "ShareCnt <n>" puis "<nom> ro|rw".
+func commandShares(ctx context.Context, writer *bufio.Writer, ip netip.Addr, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	var shares []Share
+	for _, s := range state.config.Load().partages() {
+		if s.autorise(ip) {
+			shares = append(shares, s)
+		}
+	}
+
+	header := fmt.Sprintf("%s %d\n", proto.ReponseShareCount, len(shares))
+	if err := sendrec.SendMessage(ctx, writer, header); err != nil {
+		log.Error("Failed to send ShareCnt", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+		return
+	}
+	for _, s := range shares {
+		if err := sendrec.SendMessage(ctx, writer, s.Name+" "+s.mode()+"\n"); err != nil {
+			log.Error("Failed to send share info", "share", s.Name, "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+			return
+		}
+	}
+	return
+}
+
+// commandSansPartage repond a une commande qui demande un partage alors que
+// la session n'en a pas (partage par defaut interdit au client).
+func commandSansPartage(ctx context.Context, writer *bufio.Writer, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	log.Warn("No share selected")
+	resultat = resultatInvalide
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" no share selected, use Use <share>\n"); err != nil {
+		log.Error("Failed to send Error", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE RATE ---
+// "Rate" retourne les limites actuelles, "Rate global|client <octets/s>" les modifie.
+func commandRate(ctx context.Context, writer *bufio.Writer, args []string, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	if len(args) == 0 {
+		msg := fmt.Sprintf("%s %d %d\n", proto.CommandeRate, state.debit.global.Load(), state.debit.parClient.Load())
+		if err := sendrec.SendMessage(ctx, writer, msg); err != nil {
+			log.Error("Failed to send rate", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	var rate int64 = -1
+	if len(args) == 2 {
+		if v, err := strconv.ParseInt(args[1], 10, 64); err == nil && v >= 0 {
+			rate = v
+		}
+	}
+	if rate < 0 || (args[0] != proto.RateGlobal && args[0] != proto.RateClient) {
+		log.Warn("Invalid Rate command", "args", args)
+		resultat = resultatInvalide
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" usage: Rate [global|client <bytes/s>]\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	if args[0] == proto.RateGlobal {
+		state.debit.global.Store(rate)
+	} else {
+		state.debit.parClient.Store(rate)
+	}
+	log.Info("Rate limit changed", "scope", args[0], "bytes_per_sec", rate)
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE MAXCLIENTS ---
+// "MaxClients" retourne "MaxClients <limite> <actifs> <en attente>",
+// "MaxClients <n>" modifie la limite (0 = illimite).
+func commandMaxClients(ctx context.Context, writer *bufio.Writer, args []string, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	if len(args) == 0 {
+		req := admissionInfoRequest{response: make(chan admissionInfo)}
+		state.admission <- req
+		info := <-req.response
+
+		msg := fmt.Sprintf("%s %d %d %d\n", proto.CommandeMaxClients, info.limite, info.actifs, info.enAttente)
+		if err := sendrec.SendMessage(ctx, writer, msg); err != nil {
+			log.Error("Failed to send client limit", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	limite, err := strconv.Atoi(args[0])
+	if err != nil || limite < 0 || len(args) > 1 {
+		log.Warn("Invalid MaxClients command", "args", args)
+		resultat = resultatInvalide
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" usage: MaxClients [<n>]\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	req := limiteRequest{limite: limite, response: make(chan bool)}
+	state.admission <- req
+	<-req.response
+	log.Info("Client limit changed", "max_clients", limite)
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE RELOADACL ---
+// Relit le fichier de regles d'acces. En cas d'erreur, les regles actuelles sont conservees.
+func commandReloadAcl(ctx context.Context, writer *bufio.Writer, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	aclFile := state.config.Load().AclFile
+	regles, err := chargerRegles(aclFile)
+	if err != nil {
+		log.Error("Failed to reload access rules", "file", aclFile, "error", err)
+		resultat = resultatErreur
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" "+err.Error()+"\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+
+	state.filtre.remplacer(regles)
+	log.Info("Access rules reloaded", "file", aclFile)
+
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE RELOAD ---
+// Relit la configuration et retourne les changements appliques.
+func commandReload(ctx context.Context, writer *bufio.Writer, hiddenManager chan interface{}, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	changements, err := recharger(state, hiddenManager)
+	if err != nil {
+		log.Error("Configuration reload rejected", "error", err)
+		resultat = resultatErreur
+		msg := strings.ReplaceAll(err.Error(), "\n", "; ")
+		if err := sendrec.SendMessage(ctx, writer, proto.ReponseError+" "+msg+"\n"); err != nil {
+			log.Error("Failed to send Error", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+		}
+		return
+	}
+	log.Info("Configuration reloaded", "changes", changements)
+
+	msg := fmt.Sprintf("%s %d\n", proto.ReponseReloaded, len(changements))
+	if err := sendrec.SendMessage(ctx, writer, msg); err != nil {
+		log.Error("Failed to send Reloaded", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+		return
+	}
+	for _, c := range changements {
+		if err := sendrec.SendMessage(ctx, writer, c+"\n"); err != nil {
+			log.Error("Failed to send change", "error", err)
+			state.metriques.erreur(erreurEnvoi)
+			resultat = resultatErreur
+			return
+		}
+	}
+	return
+}
+
+// --- COMMANDE RESCAN ---
+// "Rescan" relit tous les dossiers en cache, "Rescan <partage>" celui du
+// partage, sans attendre la prochaine verification. Les archives sont deja
+// relues des qu'elles changent.
+func commandRescan(ctx context.Context, writer *bufio.Writer, args []string, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+	reponse := proto.ReponseOk
+
+	dir := ""
+	rescan := state.listes != nil
+	if len(args) > 0 {
+		share, ok := state.config.Load().partage(args[0])
+		if !ok {
+			log.Warn("Rescan of unknown share", "share", args[0])
+			resultat = resultatInvalide
+			reponse = proto.ReponseError + " unknown share " + args[0]
+			rescan = false
+		} else if info, err := os.Stat(share.Path); err != nil || !info.IsDir() {
+			// Pas un dossier : rien en cache
+			rescan = false
+		}
+		dir = share.Path
+	}
+
+	if rescan {
+		n, err := state.listes.rescanner(dir)
+		if err != nil {
+			log.Error("Rescan failed", "dir", dir, "error", err)
+			state.metriques.erreur(erreurFichier)
+			resultat = resultatErreur
+			reponse = proto.ReponseError + " " + err.Error()
+		} else {
+			log.Info("Listing cache rescanned", "directories", n)
+		}
+	}
+
+	if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+		log.Error("Failed to send Rescan response", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+// --- COMMANDE LOGLEVEL ---
+// "LogLevel" retourne les niveaux actuels, "LogLevel [<sous-systeme>] <niveau>"
+// change le niveau par defaut ou celui d'un sous-systeme.
+func commandLogLevel(ctx context.Context, writer *bufio.Writer, args []string, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	reponse := proto.ReponseOk
+	var err error
+	switch len(args) {
+	case 0:
+		reponse = proto.CommandeLogLevel + " " + logging.Levels()
+	case 1:
+		err = logging.SetLevel("", args[0])
+	case 2:
+		err = logging.SetLevel(args[0], args[1])
+	default:
+		err = errors.New("usage: LogLevel [<subsystem>] <level>")
+	}
+	if err != nil {
+		log.Warn("Invalid LogLevel command", "args", args, "error", err)
+		resultat = resultatInvalide
+		reponse = proto.ReponseError + " " + err.Error()
+	} else if len(args) > 0 {
+		log.Info("Log level changed", "args", args)
+	}
+
+	if err := sendrec.SendMessage(ctx, writer, reponse+"\n"); err != nil {
+		log.Error("Failed to send LogLevel response", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+	return
+}
+
+func commandTerminate(ctx context.Context, writer *bufio.Writer, state *ServerState) (resultat string) {
+	log := logctx.From(ctx)
+	resultat = resultatOk
+
+	log.Info("Terminate command received - initiating server shutdown")
+
+	// Signale l'arret a tous les clients (il peut deja etre en cours apres un signal)
+	premier := demanderArret(state)
+	if premier {
+		// Laisser un temps au client pour recevoir le signal
+		time.Sleep(100 * time.Millisecond)
+
+		// Attend les clients qui se deconnectent (5s au plus)
+		attendreClients(log, state)
+	} else {
+		log.Warn("Server shutdown already in progress")
+		<-state.termine
+	}
+
+	// Confirmer
+	if err := sendrec.SendMessage(ctx, writer, proto.ReponseOk+"\n"); err != nil {
+		log.Error("Failed to send OK", "error", err)
+		state.metriques.erreur(erreurEnvoi)
+		resultat = resultatErreur
+	}
+
+	log.Info("Server shutdown complete")
+	if premier {
+		close(state.termine)
+	}
+	return
+}
+
+func RunServer(config Config) {
+
+	nbClients := make(chan int)
+	hiddenManager := make(chan interface{})
+	state := &ServerState{
+		shutdown:  make(chan struct{}),
+		immediat:  make(chan struct{}),
+		termine:   make(chan struct{}),
+		debit:     newLimitesDebit(config.GlobalRate, config.ClientRate, realClock{}),
+		admission: make(chan interface{}),
+		metriques: newMetriques(),
+		bus:       &busEvenements{},
+		archives:  newCacheArchives(),
+		quotas:    newQuotas(),
+		empreintes: newCacheEmpreintes(),
+	}	
+	state.config.Store(&config)
+	// Les archives servies sont fermees une fois les sessions terminees
+	defer state.archives.fermer()
+
+	// Cache des listes de fichiers, verifie en tache de fond
+	if config.ListingPoll > 0 {
+		state.listes = newCacheListes(state.bus)
+		go state.listes.surveiller(config.ListingPoll, config.ListingCheckFiles, state.shutdown)
+	}
+
+	// Compteur clients
+	go func() {
+		nb := 0
+		for c := range nbClients {
+			nb += c
+			slog.Info("Clients connectés", slog.Int("count", nb))
+			state.metriques.setClientsConnectes(nb)
+		}
+	}()
+
+	// Gestionnaire des places (nombre maximal de clients)
+	go gererAdmission(state.admission, config.MaxClients, config.QueueSize)
+
+	// Gestionnaire des fichiers caches
+	go func() {
+		// Fichiers caches par partage
+		hiddenFiles := make(map[string]map[string]bool)
+		nbCaches := 0
+		motifs := config.Hide
+		for req := range hiddenManager {
+			switch r := req.(type) {
+			case hideRequest:
+				if hiddenFiles[r.share] == nil {
+					hiddenFiles[r.share] = make(map[string]bool)
+				}
+				if !hiddenFiles[r.share][r.filename] {
+					hiddenFiles[r.share][r.filename] = true
+					nbCaches++
+				}
+				state.metriques.setFichiersCaches(nbCaches)
+				r.response <- true
+
+			case revealRequest:
+				wasHidden := hiddenFiles[r.share][r.filename]
+				if wasHidden {
+					delete(hiddenFiles[r.share], r.filename)
+					nbCaches--
+				}
+				state.metriques.setFichiersCaches(nbCaches)
+				r.response <- wasHidden
+
+			case isHiddenRequest:
+				r.response <- hiddenFiles[r.share][r.filename] || correspondMotif(motifs, r.filename)
+
+			case hidePatternsRequest:
+				r.response <- motifs
+
+			case setHidePatternsRequest:
+				motifs = r.motifs
+				r.response <- true
+
+			case listHiddenRequest:
+				copy := make(map[string]bool)
+				for k, v := range hiddenFiles[r.share] {
+					copy[k] = v
+				}
+				r.response <- copy
+			}
+		}
+	}()
+
+	// Regles d'acces par adresse
+	regles, e := chargerRegles(config.AclFile)
+	if e != nil {
+		slog.Error("Failed to load access rules", "error", e)
+		return
+	}
+	state.filtre = newFiltreIP(regles, realClock{})
+
+	// Journal d'acces
+	if config.AccessLog != "" {
+		state.accessLog, e = ouvrirAccessLog(config.AccessLog, config.AccessLogMaxSize, config.AccessLogMaxAge)
+		if e != nil {
+			slog.Error("Failed to open access log", "error", e)
+			return
+		}
+		defer state.accessLog.Close()
+	}
+
+	// Metriques au format Prometheus
+	if config.MetricsAddr != "" {
+		lMetrics, e := net.Listen("tcp", config.MetricsAddr)
+		if e != nil {
+			slog.Error("Failed to listen for metrics", "error", e)
+			return
+		}
+		defer lMetrics.Close()
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", state.metriques)
+		go func() {
+			if err := http.Serve(lMetrics, mux); err != nil {
+				slog.Debug("Metrics listener stopped", "error", err)
+			}
+		}()
+		slog.Info("Metrics available on http://" + config.MetricsAddr + "/metrics")
+	}
+
+	// Ecoute reseau principal
+	l, e := net.Listen("tcp", ":"+config.Port)
+	if e != nil {
+		slog.Error(e.Error())
+		return
+	}
+	defer func() {
+		l.Close()
+		slog.Debug("Stopped listening on port " + config.Port)
+	}()
+
+	// Ecoute reseau controle
+	lControl, e := net.Listen("tcp", ":"+config.ControlPort)
+	if e != nil {
 		slog.Error(e.Error())
 		return
 	}
 	defer func() {
 		lControl.Close()
-		slog.Debug("Stopped listening on control port " + *controlPort)
+		slog.Debug("Stopped listening on control port " + config.ControlPort)
 	}()
 
-	slog.Info("Server listening on port "+*port,
-		"control_port", *controlPort,
-		"directory", *dir)
+	if config.Dashboard {
+		go lancerTableauDeBord(state.bus, state.shutdown, os.Stdout)
+	}
+
+	// Plus de nouvelles connexions une fois l'arret demande
+	go func() {
+		<-state.shutdown
+		l.Close()
+		lControl.Close()
+	}()
+
+	// Arret sur SIGINT/SIGTERM : le premier signal arrete le serveur comme
+	// Terminate, le second coupe les clients sans attendre
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		for s := range sig {
+			if demanderArret(state) {
+				slog.Info("Signal received - initiating server shutdown", "signal", s.String())
+				go func() {
+					attendreClients(slog.Default(), state)
+					slog.Info("Server shutdown complete")
+					close(state.termine)
+				}()
+			} else {
+				slog.Warn("Signal received again - shutting down immediately", "signal", s.String())
+				arreterImmediatement(state)
+			}
+		}
+	}()
+
+	// Rechargement de la configuration sur SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			changements, err := recharger(state, hiddenManager)
+			if err != nil {
+				slog.Error("Configuration reload rejected", "error", err)
+				continue
+			}
+			slog.Info("Configuration reloaded", "changes", changements)
+		}
+	}()
+
+	slog.Info("Server listening on port "+config.Port,
+		"control_port", config.ControlPort,
+		"directory", config.Dir)
 
 	// Goroutine pour le port de controle (un seul possible)
 	go func() {
@@ -523,12 +2300,22 @@ func RunServer(port *string, controlPort *string, dir *string) {
 					return
 				default:
 					slog.Error("Control accept error", "error", e)
+					state.metriques.erreur(erreurAccept)
 					continue
 				}
 			}
 
+			ctx, id := nouvelleSession(cnx, "ctl")
+
+			// Filtrage par adresse (par defaut, connexions locales uniquement)
+			if ok, raison := state.filtre.autoriserControle(adresseIP(cnx.RemoteAddr())); !ok {
+				logctx.From(ctx).Warn("Control connection rejected", "reason", raison)
+				cnx.Close()
+				continue
+			}
+
 			// Gere le client de controle (un seul possible)
-			gererClientControle(cnx, *dir, hiddenManager, state)
+			gererClientControle(ctx, id, cnx, hiddenManager, state)
 
 			// Si la commande Terminate est execute, alors la gouroutine s'arrete
 			select {
@@ -541,24 +2328,36 @@ func RunServer(port *string, controlPort *string, dir *string) {
 
 	// Boucle d'acceptation des clients normaux
 	for {
-		select {
-		case <-state.shutdown:
-			slog.Info("Main listener shutting down")
-			return
-		default:
-		}
-
 		cnx, e := l.Accept()
 		if e != nil {
 			select {
 			case <-state.shutdown:
+				// Attend la fin de l'arret (deconnexion des clients) avant de
+				// fermer le journal d'acces
+				slog.Info("Main listener shutting down")
+				<-state.termine
 				return
 			default:
 				slog.Error(e.Error())
+				state.metriques.erreur(erreurAccept)
 				continue
 			}
 		}
 
-		go gererClient(cnx, nbClients, *dir, hiddenManager, state)
+		ctx, id := nouvelleSession(cnx, "c")
+		state.metriques.connexion()
+
+		// Filtrage par adresse et limites par adresse
+		ip := adresseIP(cnx.RemoteAddr())
+		if ok, raison := state.filtre.entrer(ip); !ok {
+			logctx.From(ctx).Warn("Connection rejected", "reason", raison)
+			cnx.Close()
+			continue
+		}
+
+		go func() {
+			gererClient(ctx, id, cnx, nbClients, hiddenManager, state)
+			state.filtre.sortir(ip)
+		}()
 	}	
 }
\ No newline at end of file
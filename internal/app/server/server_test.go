@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// connexionLocale retourne une connexion TCP sur la boucle locale ; recevoir
+// lit tout ce qui arrive de l'autre cote.
+func connexionLocale(t testing.TB, recevoir func(net.Conn)) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		recevoir(c)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// fichierTest cree un fichier de taille octets et retourne son chemin.
+func fichierTest(t testing.TB, taille int) (string, []byte) {
+	t.Helper()
+	contenu := make([]byte, taille)
+	for i := range contenu {
+		contenu[i] = byte(i * 7)
+	}
+	chemin := filepath.Join(t.TempDir(), "fichier.bin")
+	if err := os.WriteFile(chemin, contenu, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return chemin, contenu
+}
+
+func TestEnvoyerTailleFixe(t *testing.T) {
+	for _, zeroCopie := range []bool{true, false} {
+		chemin, contenu := fichierTest(t, 3<<20+17)
+		recu := make(chan []byte, 1)
+		conn := connexionLocale(t, func(c net.Conn) {
+			data, _ := io.ReadAll(c)
+			recu <- data
+		})
+		var direct net.Conn
+		if zeroCopie {
+			direct = conn
+		}
+
+		f, err := os.Open(chemin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := envoyerTailleFixe(context.Background(), direct, bufio.NewWriter(conn), f, int64(len(contenu)), &suiviProgression{progres: func(int64) {}})
+		f.Close()
+		if err != nil || n != int64(len(contenu)) {
+			t.Fatalf("zero-copy=%v: sent %d bytes, error %v", zeroCopie, n, err)
+		}
+		conn.Close()
+
+		attendu := append([]byte("Start 3145745\n"), contenu...)
+		if data := <-recu; !bytes.Equal(data, attendu) {
+			t.Errorf("zero-copy=%v: received %d bytes, want %d", zeroCopie, len(data), len(attendu))
+		}
+	}
+}
+
+// benchmarkEnvoi mesure le debit de envoyerTailleFixe sur la boucle locale,
+// avec envoi direct par le noyau (sendfile) ou par le tampon du writer.
+func benchmarkEnvoi(b *testing.B, zeroCopie bool) {
+	const taille = 32 << 20
+	chemin, _ := fichierTest(b, taille)
+	conn := connexionLocale(b, func(c net.Conn) {
+		io.Copy(io.Discard, c)
+	})
+	writer := bufio.NewWriter(conn)
+	var direct net.Conn
+	if zeroCopie {
+		direct = conn
+	}
+	suivi := &suiviProgression{progres: func(int64) {}}
+
+	b.SetBytes(taille)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(chemin)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := envoyerTailleFixe(context.Background(), direct, writer, f, taille, suivi); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+func BenchmarkEnvoyerTailleFixeZeroCopie(b *testing.B) { benchmarkEnvoi(b, true) }
+
+func BenchmarkEnvoyerTailleFixeTampon(b *testing.B) { benchmarkEnvoi(b, false) }
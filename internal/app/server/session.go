@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync/atomic"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logctx"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
+)
+
+// Prefixe aleatoire propre au processus : les identifiants restent uniques
+// meme si plusieurs executions du serveur ecrivent dans les memes logs
+var prefixeSession = func() string {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "000000"
+	}
+	return hex.EncodeToString(b)
+}()
+
+var compteurSessions atomic.Uint64
+
+// nouvelleSession attribue un identifiant a une connexion acceptee et retourne
+// un contexte portant un logger qui ajoute l'identifiant et l'adresse du
+// client a chaque ligne. genre distingue les sessions clients ("c")
+// des sessions de controle ("ctl"), qui sont aussi des sous-systemes de log
+// distincts ("session" et "control").
+func nouvelleSession(cnx net.Conn, genre string) (context.Context, string) {
+	id := fmt.Sprintf("%s-%s%d", prefixeSession, genre, compteurSessions.Add(1))
+	sousSysteme := "session"
+	if genre == "ctl" {
+		sousSysteme = "control"
+	}
+	logger := slog.Default().With(logging.CleSousSysteme, sousSysteme, "session", id, "client", cnx.RemoteAddr().String())
+	return logctx.With(context.Background(), logger), id
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Nom du partage utilise au debut de chaque session (le dossier -dir,
+// sauf si un partage de ce nom est declare)
+const partageParDefaut = "default"
+
+// Share est un dossier (ou une archive) servi sous un nom, choisi par le client avec "Use <nom>".
+type Share struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	// Stockage : "local" (dossier), "zip", "tar" ou "tgz" (archive en lecture
+	// seule, Path est alors le fichier de l'archive). Vide : selon Path.
+	Backend string `json:"backend"`
+	// Autorise les modifications (sinon partage en lecture seule)
+	Writable bool `json:"writable"`
+	// Taille maximale des fichiers du partage, en octets, verifiee avant
+	// chaque envoi (0 = illimite)
+	Quota int64 `json:"quota"`
+	// Motifs (filepath.Match) des fichiers toujours caches dans ce partage
+	Hide []string `json:"hide"`
+	// Reseaux autorises a utiliser ce partage (vide = tous)
+	Allow []string `json:"allow"`
+}
+
+// partages retourne les partages de la configuration : "default" (Dir),
+// puis les partages declares.
+func (c *Config) partages() []Share {
+	liste := make([]Share, 0, len(c.Shares)+1)
+	if _, ok := c.partageDeclare(partageParDefaut); !ok {
+		liste = append(liste, Share{Name: partageParDefaut, Path: c.Dir})
+	}
+	return append(liste, c.Shares...)
+}
+
+// partage retourne le partage nom.
+func (c *Config) partage(nom string) (Share, bool) {
+	if nom == partageParDefaut {
+		if s, ok := c.partageDeclare(nom); ok {
+			return s, true
+		}
+		return Share{Name: partageParDefaut, Path: c.Dir}, true
+	}
+	return c.partageDeclare(nom)
+}
+
+func (c *Config) partageDeclare(nom string) (Share, bool) {
+	for _, s := range c.Shares {
+		if s.Name == nom {
+			return s, true
+		}
+	}
+	return Share{}, false
+}
+
+// autorise indique si l'adresse ip peut utiliser le partage. Une adresse
+// invalide (sessions du port de controle) a acces a tous les partages.
+func (s Share) autorise(ip netip.Addr) bool {
+	if len(s.Allow) == 0 || !ip.IsValid() {
+		return true
+	}
+	for _, reseau := range s.Allow {
+		if p, err := parsePrefix(reseau); err == nil && p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// mode retourne "rw" pour un partage modifiable, "ro" sinon.
+func (s Share) mode() string {
+	if s.Writable {
+		return "rw"
+	}
+	return "ro"
+}
+
+// ParseShare analyse la definition d'un partage sur la ligne de commande :
+// "<nom>=<dossier>" ou "<nom>=<dossier>,rw" pour un partage modifiable.
+func ParseShare(def string) (Share, error) {
+	nom, reste, ok := strings.Cut(def, "=")
+	if !ok || nom == "" || reste == "" {
+		return Share{}, fmt.Errorf("invalid share %q (expected name=path[,rw])", def)
+	}
+	s := Share{Name: nom, Path: reste}
+	if chemin, mode, ok := strings.Cut(reste, ","); ok {
+		s.Path = chemin
+		switch mode {
+		case "rw":
+			s.Writable = true
+		case "ro":
+		default:
+			return Share{}, fmt.Errorf("invalid share mode %q in %q (expected ro or rw)", mode, def)
+		}
+	}
+	return s, nil
+}
+
+// validerPartages verifie les partages declares.
+func validerPartages(shares []Share, ajouter func(format string, args ...any)) {
+	noms := make(map[string]bool)
+	for i, s := range shares {
+		cle := fmt.Sprintf("shares[%d]", i)
+		if s.Name == "" || strings.ContainsAny(s.Name, " \t\n/") {
+			ajouter("%s.name: invalid share name %q", cle, s.Name)
+		} else if noms[s.Name] {
+			ajouter("%s.name: duplicate share %q", cle, s.Name)
+		}
+		noms[s.Name] = true
+
+		backend := s.Backend
+		if backend == "" {
+			var err error
+			if backend, err = detecterStockage(s.Path); err != nil {
+				ajouter("%s.path: %v", cle, err)
+				backend = "?"
+			}
+		}
+		switch backend {
+		case "?":
+		case stockageDossier:
+			if info, err := os.Stat(s.Path); err != nil {
+				ajouter("%s.path: %v", cle, err)
+			} else if !info.IsDir() {
+				ajouter("%s.path: %s is not a directory", cle, s.Path)
+			}
+		case stockageZip, stockageTar, stockageTarGz:
+			if info, err := os.Stat(s.Path); err != nil {
+				ajouter("%s.path: %v", cle, err)
+			} else if !info.Mode().IsRegular() {
+				ajouter("%s.path: %s is not an archive file", cle, s.Path)
+			}
+			if s.Writable {
+				ajouter("%s.writable: archives are read-only", cle)
+			}
+			if s.Quota != 0 {
+				ajouter("%s.quota: archives are read-only", cle)
+			}
+		default:
+			ajouter("%s.backend: unknown backend %q (expected local, zip, tar or tgz)", cle, s.Backend)
+		}
+		if s.Quota < 0 {
+			ajouter("%s.quota: must be 0 or more, got %d", cle, s.Quota)
+		}
+		for j, motif := range s.Hide {
+			if _, err := filepath.Match(motif, ""); err != nil {
+				ajouter("%s.hide[%d]: invalid pattern %q", cle, j, motif)
+			}
+		}
+		for j, reseau := range s.Allow {
+			if _, err := parsePrefix(reseau); err != nil {
+				ajouter("%s.allow[%d]: %v", cle, j, err)
+			}
+		}
+	}
+}
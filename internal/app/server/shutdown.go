@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Duree maximale d'attente des clients lors d'un arret
+const delaiArret = 5 * time.Second
+
+// Duree laissee aux sessions coupees pour ecrire le journal d'acces
+const delaiFermeture = 1 * time.Second
+
+// demanderArret signale l'arret a tous les clients et ferme les ports d'ecoute.
+// Retourne false si l'arret etait deja demande.
+func demanderArret(state *ServerState) bool {
+	premier := false
+	state.arret.Do(func() {
+		close(state.shutdown)
+		premier = true
+	})
+	return premier
+}
+
+// arreterImmediatement ferme les connexions des clients, meme pendant un transfert.
+func arreterImmediatement(state *ServerState) {
+	state.arretImmediat.Do(func() {
+		close(state.immediat)
+	})
+}
+
+// attendreClients attend la deconnexion des clients, au plus delaiArret ou
+// jusqu'a un arret immediat. Les clients encore connectes sont alors coupes.
+func attendreClients(log *slog.Logger, state *ServerState) {
+	log.Info("Waiting for all clients to disconnect...")
+
+	done := make(chan struct{})
+	go func() {
+		state.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("All clients disconnected")
+		return
+	case <-time.After(delaiArret):
+		log.Warn("Timeout waiting for clients to disconnect, forcing shutdown")
+		arreterImmediatement(state)
+	case <-state.immediat:
+		log.Warn("Immediate shutdown, closing client connections")
+	}
+
+	// Les sessions coupees se terminent et ecrivent leur ligne du journal d'acces
+	select {
+	case <-done:
+	case <-time.After(delaiFermeture):
+		log.Warn("Some client sessions did not end in time")
+	}
+}
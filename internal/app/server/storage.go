@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types de stockage d'un partage (Share.Backend)
+const (
+	stockageDossier = "local"
+	stockageZip     = "zip"
+	stockageTar     = "tar"
+	stockageTarGz   = "tgz"
+)
+
+// stockage est la source des fichiers d'un partage : un dossier local, une
+// archive en lecture seule, ou des fichiers en memoire (tests).
+// Les noms sont relatifs a la racine du partage, au format de fs.ValidPath.
+type stockage interface {
+	// lister retourne les fichiers servis (sans les dossiers), tries par nom
+	lister() ([]fs.FileInfo, error)
+	// stat retourne les informations d'un fichier ou d'un dossier
+	stat(name string) (fs.FileInfo, error)
+	// ouvrir ouvre un fichier en lecture
+	ouvrir(name string) (io.ReadSeekCloser, error)
+}
+
+// ouvrirStockage retourne le stockage d'un partage. Sans Backend, le stockage
+// depend de Path : un dossier, ou une archive reconnue a son contenu.
+// Les archives sont gardees ouvertes dans le cache du serveur.
+func ouvrirStockage(state *ServerState, share Share) (stockage, error) {
+	switch share.Backend {
+	case "":
+		info, err := os.Stat(share.Path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return stockageLocal{dir: share.Path, cache: state.listes}, nil
+		}
+		return state.archives.ouvrir("", share.Path)
+	case stockageDossier:
+		return stockageLocal{dir: share.Path, cache: state.listes}, nil
+	case stockageZip, stockageTar, stockageTarGz:
+		return state.archives.ouvrir(share.Backend, share.Path)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", share.Backend)
+	}
+}
+
+// detecterStockage retourne le type de stockage de chemin : un dossier ou une archive.
+func detecterStockage(chemin string) (string, error) {
+	info, err := os.Stat(chemin)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return stockageDossier, nil
+	}
+	return detecterArchive(chemin)
+}
+
+// stockageLocal sert un dossier du disque.
+type stockageLocal struct {
+	dir string
+	// Listes des dossiers partagees entre les sessions (nil = pas de cache)
+	cache *cacheListes
+}
+
+// chemin retourne le chemin sur le disque du fichier name. Les fichiers
+// temporaires des envois en cours (Put) sont traites comme absents : aucune
+// commande ne peut lire un envoi partiel.
+func (s stockageLocal) chemin(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if strings.HasPrefix(path.Base(name), prefixeEnvoi) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
+
+func (s stockageLocal) lister() ([]fs.FileInfo, error) {
+	if s.cache != nil {
+		return s.cache.lister(s.dir)
+	}
+	return lireDossier(s.dir)
+}
+
+// lireDossier retourne les fichiers de dir (sans les sous-dossiers), tries par nom.
+func lireDossier(dir string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []fs.FileInfo
+	for _, e := range entries {
+		// Les envois en cours ne sont pas encore des fichiers du partage
+		if e.IsDir() || strings.HasPrefix(e.Name(), prefixeEnvoi) {
+			continue
+		}
+		// Un fichier supprime entre-temps est ignore
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s stockageLocal) stat(name string) (fs.FileInfo, error) {
+	chemin, err := s.chemin("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(chemin)
+}
+
+func (s stockageLocal) ouvrir(name string) (io.ReadSeekCloser, error) {
+	chemin, err := s.chemin("open", name)
+	if err != nil {
+		return nil, err
+	}
+	// *os.File : permet l'envoi sans copie (voir envoyerTailleFixe)
+	return os.Open(chemin)
+}
+
+// stockageMemoire sert des fichiers gardes en memoire, pour les tests.
+type stockageMemoire struct {
+	fichiers map[string][]byte
+	modif    time.Time
+}
+
+func newStockageMemoire(fichiers map[string][]byte) *stockageMemoire {
+	return &stockageMemoire{fichiers: fichiers, modif: time.Now()}
+}
+
+func (s *stockageMemoire) lister() ([]fs.FileInfo, error) {
+	infos := make([]fs.FileInfo, 0, len(s.fichiers))
+	for nom, data := range s.fichiers {
+		infos = append(infos, infoFichier{nom: nom, taille: int64(len(data)), modif: s.modif})
+	}
+	trierInfos(infos)
+	return infos, nil
+}
+
+func (s *stockageMemoire) stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	data, ok := s.fichiers[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return infoFichier{nom: name, taille: int64(len(data)), modif: s.modif}, nil
+}
+
+func (s *stockageMemoire) ouvrir(name string) (io.ReadSeekCloser, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	data, ok := s.fichiers[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return lecteurSansFermeture{bytes.NewReader(data)}, nil
+}
+
+// infoFichier decrit un fichier qui n'est pas sur le disque (memoire, membre
+// d'archive). Le nom est le chemin complet dans le partage.
+type infoFichier struct {
+	nom    string
+	taille int64
+	modif  time.Time
+}
+
+func (i infoFichier) Name() string       { return i.nom }
+func (i infoFichier) Size() int64        { return i.taille }
+func (i infoFichier) Mode() fs.FileMode  { return 0o444 }
+func (i infoFichier) ModTime() time.Time { return i.modif }
+func (i infoFichier) IsDir() bool        { return false }
+func (i infoFichier) Sys() any           { return nil }
+
+func trierInfos(infos []fs.FileInfo) {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+}
+
+// lecteurSansFermeture ajoute un Close sans effet a un io.ReadSeeker.
+type lecteurSansFermeture struct {
+	io.ReadSeeker
+}
+
+func (lecteurSansFermeture) Close() error { return nil }
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stockagesTest retourne un stockage local et un stockage en memoire
+// contenant les memes fichiers.
+func stockagesTest(t *testing.T) map[string]stockage {
+	t.Helper()
+	fichiers := map[string][]byte{
+		"b.txt": []byte("Contenu du fichier B"),
+		"a.txt": []byte("Contenu du fichier A"),
+	}
+
+	dir := t.TempDir()
+	for nom, data := range fichiers {
+		if err := os.WriteFile(filepath.Join(dir, nom), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Ni les sous-dossiers ni les envois en cours ne sont listes
+	if err := os.Mkdir(filepath.Join(dir, "sous-dossier"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, prefixeEnvoi+"c.txt"), []byte("partiel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sous-dossier", prefixeEnvoi+"d.txt"), []byte("partiel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Fichier hors du partage, qui ne doit pas etre accessible
+	if err := os.WriteFile(filepath.Join(filepath.Dir(dir), "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]stockage{
+		"local":   stockageLocal{dir: dir},
+		"memoire": newStockageMemoire(fichiers),
+	}
+}
+
+func TestStockageLister(t *testing.T) {
+	for nom, s := range stockagesTest(t) {
+		t.Run(nom, func(t *testing.T) {
+			infos, err := s.lister()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var noms []string
+			for _, info := range infos {
+				noms = append(noms, info.Name())
+			}
+			if len(noms) != 2 || noms[0] != "a.txt" || noms[1] != "b.txt" {
+				t.Errorf("lister = %v, attendu [a.txt b.txt]", noms)
+			}
+		})
+	}
+}
+
+func TestStockageStat(t *testing.T) {
+	for nom, s := range stockagesTest(t) {
+		t.Run(nom, func(t *testing.T) {
+			info, err := s.stat("a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len("Contenu du fichier A")) || info.IsDir() {
+				t.Errorf("stat(a.txt) : taille %d, dossier %v", info.Size(), info.IsDir())
+			}
+
+			if _, err := s.stat("absent.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("stat(absent.txt) : erreur %v, attendu fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStockageOuvrir(t *testing.T) {
+	for nom, s := range stockagesTest(t) {
+		t.Run(nom, func(t *testing.T) {
+			f, err := s.ouvrir("b.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			if _, err := f.Seek(8, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "du fichier B" {
+				t.Errorf("lecture apres Seek = %q", data)
+			}
+
+			if _, err := s.ouvrir("absent.txt"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("ouvrir(absent.txt) : erreur %v, attendu fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestStockageEnvoiEnCours(t *testing.T) {
+	s := stockagesTest(t)["local"]
+	for _, nom := range []string{prefixeEnvoi + "c.txt", "sous-dossier/" + prefixeEnvoi + "d.txt"} {
+		if _, err := s.stat(nom); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("stat(%q) : erreur %v, attendu fs.ErrNotExist", nom, err)
+		}
+		if f, err := s.ouvrir(nom); !errors.Is(err, fs.ErrNotExist) {
+			if err == nil {
+				f.Close()
+			}
+			t.Errorf("ouvrir(%q) : erreur %v, attendu fs.ErrNotExist", nom, err)
+		}
+	}
+}
+
+func TestStockageCheminInvalide(t *testing.T) {
+	for nom, s := range stockagesTest(t) {
+		t.Run(nom, func(t *testing.T) {
+			for _, chemin := range []string{"../secret.txt", "/etc/passwd", "./a.txt", "", "a/../../secret.txt"} {
+				if _, err := s.stat(chemin); !errors.Is(err, fs.ErrInvalid) {
+					t.Errorf("stat(%q) : erreur %v, attendu fs.ErrInvalid", chemin, err)
+				}
+				if f, err := s.ouvrir(chemin); !errors.Is(err, fs.ErrInvalid) {
+					if err == nil {
+						f.Close()
+					}
+					t.Errorf("ouvrir(%q) : erreur %v, attendu fs.ErrInvalid", chemin, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Taille maximale d'une ecriture entre deux controles du debit
+const tailleTranche = 16 * 1024
+
+// clock abstrait l'horloge pour pouvoir simuler l'ecoulement du temps.
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// realClock utilise l'horloge du systeme.
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// tokenBucket limite un debit en octets par seconde.
+// Le debit est lu a chaque reservation, il peut donc etre modifie a chaud.
+// Un meme seau peut etre partage entre plusieurs goroutines.
+type tokenBucket struct {
+	mu     sync.Mutex
+	clock  clock
+	rate   *atomic.Int64 // octets/s, 0 = illimite
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate *atomic.Int64, c clock) *tokenBucket {
+	return &tokenBucket{rate: rate, clock: c}
+}
+
+// reserve preleve n jetons et retourne le temps a attendre avant de
+// pouvoir envoyer les n octets. Le solde peut devenir negatif (dette).
+func (b *tokenBucket) reserve(n int) time.Duration {
+	rate := b.rate.Load()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if rate <= 0 {
+		b.tokens = 0
+		b.last = now
+		return 0
+	}
+
+	// Remplissage depuis la derniere reservation, plafonne a 100ms
+	// de debit (au moins une tranche pour ne jamais bloquer)
+	burst := float64(max(rate/10, tailleTranche))
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	} else {
+		b.tokens = burst
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// throttledWriter ecrit sur w en respectant tous les seaux fournis
+// (typiquement celui de la connexion et celui du serveur).
+type throttledWriter struct {
+	w       io.Writer
+	buckets []*tokenBucket
+	clock   clock
+}
+
+// Write decoupe p en tranches et attend entre chaque tranche si besoin.
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), tailleTranche)
+
+		var wait time.Duration
+		for _, b := range tw.buckets {
+			if d := b.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			tw.clock.Sleep(wait)
+		}
+
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// limitesDebit regroupe les limites de debit du serveur (octets/s, 0 = illimite).
+type limitesDebit struct {
+	global    atomic.Int64
+	parClient atomic.Int64
+	clock     clock
+	// Seau partage par toutes les connexions
+	seauGlobal *tokenBucket
+}
+
+func newLimitesDebit(global, parClient int64, c clock) *limitesDebit {
+	l := &limitesDebit{clock: c}
+	l.global.Store(global)
+	l.parClient.Store(parClient)
+	l.seauGlobal = newTokenBucket(&l.global, c)
+	return l
+}
+
+// actif indique si au moins une limite est en vigueur.
+func (l *limitesDebit) actif() bool {
+	return l.global.Load() > 0 || l.parClient.Load() > 0
+}
+
+// writer enveloppe w avec un seau propre a la connexion et le seau global.
+func (l *limitesDebit) writer(w io.Writer) io.Writer {
+	return &throttledWriter{
+		w:       w,
+		buckets: []*tokenBucket{newTokenBucket(&l.parClient, l.clock), l.seauGlobal},
+		clock:   l.clock,
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// horlogeManuelle est une horloge simulee : Sleep avance le temps sans attendre.
+type horlogeManuelle struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newHorlogeManuelle() *horlogeManuelle {
+	return &horlogeManuelle{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (h *horlogeManuelle) Now() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.now
+}
+
+func (h *horlogeManuelle) Sleep(d time.Duration) {
+	h.avancer(d)
+}
+
+func (h *horlogeManuelle) avancer(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.now = h.now.Add(d)
+}
+
+// ecrire envoie total octets sur w par ecritures de taille octets et
+// retourne le temps simule ecoule.
+func ecrire(t *testing.T, h *horlogeManuelle, w io.Writer, total, taille int) time.Duration {
+	t.Helper()
+	debut := h.Now()
+	p := make([]byte, taille)
+	for envoye := 0; envoye < total; envoye += taille {
+		if _, err := w.Write(p[:min(taille, total-envoye)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h.Now().Sub(debut)
+}
+
+// duree retourne le temps d'envoi de octets au debit rate.
+func duree(octets, rate int) time.Duration {
+	return time.Duration(float64(octets) / float64(rate) * float64(time.Second))
+}
+
+// proche verifie que d est a 2% pres de attendu.
+func proche(t *testing.T, nom string, d, attendu time.Duration) {
+	t.Helper()
+	if math.Abs(float64(d-attendu)) > 0.02*float64(attendu) {
+		t.Errorf("%s: %v, want about %v", nom, d, attendu)
+	}
+}
+
+func TestTokenBucketDebitRegulier(t *testing.T) {
+	h := newHorlogeManuelle()
+	var rate atomic.Int64
+	rate.Store(100_000)
+	w := &throttledWriter{w: io.Discard, buckets: []*tokenBucket{newTokenBucket(&rate, h)}, clock: h}
+
+	// Le premier envoi profite de la reserve initiale (une tranche)
+	d := ecrire(t, h, w, 1_000_000, 4096)
+	proche(t, "1 MB at 100 kB/s", d, duree(1_000_000-tailleTranche, 100_000))
+}
+
+func TestTokenBucketRafale(t *testing.T) {
+	h := newHorlogeManuelle()
+	var rate atomic.Int64
+	rate.Store(1_000_000)
+	b := newTokenBucket(&rate, h)
+
+	// Reserve pleine au depart : 100 ms de debit
+	if d := b.reserve(100_000); d != 0 {
+		t.Errorf("first burst waits %v, want 0", d)
+	}
+	if d := b.reserve(50_000); d != 50*time.Millisecond {
+		t.Errorf("over burst waits %v, want 50ms", d)
+	}
+
+	// Apres une longue inactivite, la reserve est plafonnee
+	h.avancer(10 * time.Second)
+	if d := b.reserve(100_000); d != 0 {
+		t.Errorf("burst after idle waits %v, want 0", d)
+	}
+	if d := b.reserve(100_000); d != 100*time.Millisecond {
+		t.Errorf("second burst after idle waits %v, want 100ms", d)
+	}
+}
+
+func TestTokenBucketChangementDebit(t *testing.T) {
+	h := newHorlogeManuelle()
+	var rate atomic.Int64
+	rate.Store(100_000)
+	w := &throttledWriter{w: io.Discard, buckets: []*tokenBucket{newTokenBucket(&rate, h)}, clock: h}
+	ecrire(t, h, w, 200_000, tailleTranche)
+
+	// Debit double : le meme volume prend deux fois moins de temps
+	rate.Store(200_000)
+	proche(t, "500 kB at 200 kB/s", ecrire(t, h, w, 500_000, tailleTranche), 2500*time.Millisecond)
+
+	// Plus de limite : aucune attente
+	rate.Store(0)
+	if d := ecrire(t, h, w, 10_000_000, tailleTranche); d != 0 {
+		t.Errorf("unlimited write took %v, want 0", d)
+	}
+
+	// La reserve a ete videe pendant la periode sans limite
+	rate.Store(50_000)
+	proche(t, "500 kB at 50 kB/s", ecrire(t, h, w, 500_000, tailleTranche), duree(500_000, 50_000))
+}
+
+func TestLimitesDebitGlobalPartage(t *testing.T) {
+	h := newHorlogeManuelle()
+	l := newLimitesDebit(100_000, 0, h)
+	a := l.writer(io.Discard)
+	b := l.writer(io.Discard)
+
+	// Deux connexions qui envoient en alternance se partagent le debit global
+	debut := h.Now()
+	var tempsA, tempsB time.Duration
+	for i := 0; i < 40; i++ {
+		tempsA += ecrire(t, h, a, tailleTranche, tailleTranche)
+		tempsB += ecrire(t, h, b, tailleTranche, tailleTranche)
+	}
+	total := h.Now().Sub(debut)
+	proche(t, "2 x 40 slices at 100 kB/s", total, duree(79*tailleTranche, 100_000))
+	// Chaque connexion attend autant, a la reserve initiale pres (prise par a)
+	proche(t, "connection share", tempsA+duree(tailleTranche, 100_000), tempsB)
+
+	// La limite par connexion s'ajoute a la limite globale
+	l.parClient.Store(10_000)
+	l.global.Store(0)
+	c := l.writer(io.Discard)
+	proche(t, "client limit", ecrire(t, h, c, 100_000, tailleTranche), duree(100_000-tailleTranche, 10_000))
+}
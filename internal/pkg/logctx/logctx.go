@@ -0,0 +1,26 @@
+// Package logctx associe un slog.Logger a un context.Context, pour que
+// toutes les lignes de log d'une session portent les memes attributs
+// (identifiant de session, adresse du client).
+package logctx
+
+import (
+	"context"
+	"log/slog"
+)
+
+type cle struct{}
+
+// With retourne un contexte qui porte logger.
+func With(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, cle{}, logger)
+}
+
+// From retourne le logger porte par ctx, ou le logger par defaut.
+func From(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(cle{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
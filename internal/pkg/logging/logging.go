@@ -0,0 +1,218 @@
+// Package logging configure le logger par defaut (format, fichier de sortie)
+// et permet de regler le niveau de log globalement ou par sous-systeme,
+// y compris pendant l'execution.
+//
+// Le sous-systeme d'un logger est donne par l'attribut "subsystem"
+// (par exemple slog.Default().With("subsystem", "protocol")).
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CleSousSysteme est la cle de l'attribut qui designe le sous-systeme d'un logger.
+const CleSousSysteme = "subsystem"
+
+// Options de configuration du logger par defaut.
+type Options struct {
+	// Format de sortie : "text" (defaut) ou "json"
+	Format string
+	// Fichier de sortie (ajout en fin de fichier), vide pour la sortie d'erreur
+	File string
+	// Niveau par defaut
+	Level slog.Level
+	// Niveaux par sous-systeme, au format "protocol=debug,control=warn"
+	Subsystems string
+}
+
+// Niveaux de log courants, partages par tous les loggers crees depuis Setup
+var niveaux = &registre{parSousSysteme: make(map[string]*slog.LevelVar)}
+
+type registre struct {
+	mu             sync.RWMutex
+	defaut         slog.LevelVar
+	parSousSysteme map[string]*slog.LevelVar
+}
+
+func (r *registre) niveau(sousSysteme string) slog.Level {
+	if sousSysteme != "" {
+		r.mu.RLock()
+		v, ok := r.parSousSysteme[sousSysteme]
+		r.mu.RUnlock()
+		if ok {
+			return v.Level()
+		}
+	}
+	return r.defaut.Level()
+}
+
+// Setup installe le logger par defaut selon opts. Le fichier de sortie
+// eventuel reste ouvert jusqu'a la fin du processus.
+func Setup(opts Options) error {
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+
+	// Le filtrage est fait par handler : le handler interne accepte tout
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var inner slog.Handler
+	switch opts.Format {
+	case "", "text":
+		inner = slog.NewTextHandler(out, handlerOpts)
+	case "json":
+		inner = slog.NewJSONHandler(out, handlerOpts)
+	default:
+		return fmt.Errorf("unknown log format %q (expected text or json)", opts.Format)
+	}
+
+	parSousSysteme, err := ParseSubsystems(opts.Subsystems)
+	if err != nil {
+		return err
+	}
+	niveaux.defaut.Set(opts.Level)
+	for nom, l := range parSousSysteme {
+		setLevel(nom, l)
+	}
+
+	slog.SetDefault(slog.New(&handler{inner: inner}))
+	return nil
+}
+
+// ParseLevel convertit un nom de niveau (debug, info, warn, error) en slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// ParseSubsystems analyse des niveaux par sous-systeme au format
+// "protocol=debug,control=warn". Une chaine vide donne une table vide.
+func ParseSubsystems(s string) (map[string]slog.Level, error) {
+	parSousSysteme := make(map[string]slog.Level)
+	if s == "" {
+		return parSousSysteme, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		nom, valeur, ok := strings.Cut(item, "=")
+		if !ok || nom == "" {
+			return nil, fmt.Errorf("invalid subsystem level %q (expected name=level)", item)
+		}
+		l, err := ParseLevel(valeur)
+		if err != nil {
+			return nil, fmt.Errorf("subsystem %s: %w", nom, err)
+		}
+		parSousSysteme[nom] = l
+	}
+	return parSousSysteme, nil
+}
+
+// SetLevels remplace tous les niveaux : le niveau par defaut et ceux des
+// sous-systemes (les sous-systemes absents de subsystems reprennent le defaut).
+func SetLevels(defaut slog.Level, subsystems string) error {
+	parSousSysteme, err := ParseSubsystems(subsystems)
+	if err != nil {
+		return err
+	}
+	niveaux.mu.Lock()
+	niveaux.parSousSysteme = make(map[string]*slog.LevelVar)
+	niveaux.mu.Unlock()
+
+	niveaux.defaut.Set(defaut)
+	for nom, l := range parSousSysteme {
+		setLevel(nom, l)
+	}
+	return nil
+}
+
+// SetLevel change le niveau d'un sous-systeme, ou le niveau par defaut
+// si sousSysteme est vide.
+func SetLevel(sousSysteme string, niveau string) error {
+	l, err := ParseLevel(niveau)
+	if err != nil {
+		return err
+	}
+	setLevel(sousSysteme, l)
+	return nil
+}
+
+func setLevel(sousSysteme string, l slog.Level) {
+	if sousSysteme == "" {
+		niveaux.defaut.Set(l)
+		return
+	}
+
+	niveaux.mu.Lock()
+	defer niveaux.mu.Unlock()
+	v, ok := niveaux.parSousSysteme[sousSysteme]
+	if !ok {
+		v = new(slog.LevelVar)
+		niveaux.parSousSysteme[sousSysteme] = v
+	}
+	v.Set(l)
+}
+
+// Levels decrit les niveaux courants : "INFO protocol=DEBUG ...".
+func Levels() string {
+	niveaux.mu.RLock()
+	defer niveaux.mu.RUnlock()
+
+	parts := []string{niveaux.defaut.Level().String()}
+	noms := make([]string, 0, len(niveaux.parSousSysteme))
+	for nom := range niveaux.parSousSysteme {
+		noms = append(noms, nom)
+	}
+	sort.Strings(noms)
+	for _, nom := range noms {
+		parts = append(parts, nom+"="+niveaux.parSousSysteme[nom].Level().String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// handler filtre les enregistrements selon le niveau de leur sous-systeme.
+// L'attribut "subsystem" n'est ecrit qu'une fois, avec la derniere valeur.
+type handler struct {
+	inner       slog.Handler
+	sousSysteme string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= niveaux.niveau(h.sousSysteme)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if h.sousSysteme != "" {
+		r.AddAttrs(slog.String(CleSousSysteme, h.sousSysteme))
+	}
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sousSysteme := h.sousSysteme
+	autres := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Key == CleSousSysteme {
+			sousSysteme = a.Value.String()
+			continue
+		}
+		autres = append(autres, a)
+	}
+	return &handler{inner: h.inner.WithAttrs(autres), sousSysteme: sousSysteme}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{inner: h.inner.WithGroup(name), sousSysteme: h.sousSysteme}
+}
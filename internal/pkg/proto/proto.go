@@ -5,6 +5,9 @@ const (
 
 	// Partie 1 : Commandes envoyées par le.s client.s au serveur
 	CommandeList = "List"
+	// "List long" : lignes "<nom> <taille> <date>", avec la date de
+	// modification du fichier (RFC 3339, UTC)
+	ListeDetaillee = "long"
 	CommandeGet = "Get"
 	CommandeEnd = "End"
 
@@ -12,11 +15,90 @@ const (
 	CommandeHide = "Hide"
 	CommandeReveal = "Reveal"
 	CommandeTerminate = "Terminate"
+	// "Use <partage>" : change de partage, "Shares" : liste des partages
+	// ("ShareCnt <n>" suivi de <n> lignes "<nom> ro|rw")
+	CommandeUse = "Use"
+	CommandeShares = "Shares"
+	// "Rate" ou "Rate global|client <octets/s>" : limites de debit
+	CommandeRate = "Rate"
+	RateGlobal = "global"
+	RateClient = "client"
+	// "MaxClients" ou "MaxClients <n>" : nombre maximal de clients
+	CommandeMaxClients = "MaxClients"
+	// "ReloadAcl" : relit le fichier de regles d'acces
+	CommandeReloadAcl = "ReloadAcl"
+	// "LogLevel" ou "LogLevel [<sous-systeme>] <niveau>" : niveaux de log
+	CommandeLogLevel = "LogLevel"
+	// "Reload" : relit la configuration, reponse "Reloaded <n>" suivie
+	// des <n> changements (une ligne chacun)
+	CommandeReload = "Reload"
+	// "Rescan [<partage>]" : relit les dossiers en cache (tous, ou celui du partage)
+	CommandeRescan = "Rescan"
+	// "Watch [<motif>]" : reponse "OK" puis une ligne "<evenement> <nom>" par
+	// changement dans le partage, jusqu'a "Stop" (reponse "OK")
+	CommandeWatch = "Watch"
+	CommandeStop = "Stop"
+	// "Follow <fichier> [<n>]" : "Start chunked", puis le contenu (ou les <n>
+	// dernieres lignes) et la suite du fichier au fil de l'eau, jusqu'a "Stop"
+	// (le serveur termine alors le flux par le bloc de fin)
+	CommandeFollow = "Follow"
+	// "Delete <nom>", "Rename <ancien> <nouveau>", "Mkdir <dossier>" : modifient
+	// un partage modifiable (reponse "OK", "FileUnknown" ou "Error <message>")
+	CommandeDelete = "Delete"
+	CommandeRename = "Rename"
+	CommandeMkdir = "Mkdir"
+	// "Put <nom> <taille>" : reponse "OK" (ou "QuotaExceeded <portee> <limite>"),
+	// puis le client envoie le contenu par blocs avec le trailer Sha256 ;
+	// le serveur confirme par "OK"
+	CommandePut = "Put"
+	// "Quota" : utilisation des partages et des utilisateurs, reponse
+	// "QuotaCnt <n>" suivie de <n> lignes "share|user <nom> <utilise> <limite>"
+	CommandeQuota = "Quota"
+	// "Hash <fichier>" : taille et empreinte SHA-256 du fichier, reponse
+	// "Hash <taille> <sha256>" (ou "FileUnknown")
+	CommandeHash = "Hash"
 
 	// Réponses du serveur au.x client.s
 	ReponseFileCount = "FileCnt"
 	ReponseFileUnknown = "FileUnknown"
+	ReponseShareCount = "ShareCnt"
 	ReponseStart = "Start"
 	ReponseOk = "OK"
+	// "Error <message>" : commande invalide ou refusee
+	ReponseError = "Error"
+	// Nombre maximal de clients atteint : "Busy" (connexion refusee),
+	// ou "Queued <position>" jusqu'a obtenir une place ("Ready")
+	ReponseBusy = "Busy"
+	ReponseQueued = "Queued"
+	ReponseReady = "Ready"
+	ReponseReloaded = "Reloaded"
+	// Envoi refuse : "QuotaExceeded file|share|user <limite>"
+	ReponseQuotaExceeded = "QuotaExceeded"
+	ReponseQuotaCount = "QuotaCnt"
+	ReponseHash = "Hash"
+
+	// Evenements envoyes pendant Watch
+	EvenementAjout = "Added"
+	EvenementSuppression = "Removed"
+	EvenementModification = "Modified"
+	EvenementCache = "Hidden"
+	EvenementRevele = "Revealed"
+	// Des changements ont ete perdus : le client doit relire la liste
+	EvenementResync = "Resync"
+
+	// Transfert par blocs : "Start chunked" remplace "Start <size>"
+	ModeChunked = "chunked"
+	// Trailer envoyé après le dernier bloc : empreinte SHA-256 du contenu
+	TrailerSha256 = "Sha256"
+
+	// Encodages de compression : le client les annonce apres le nom du fichier
+	// ("Get <filename> gzip,deflate"), le serveur indique celui qu'il a choisi
+	// ("Start chunked gzip")
+	EncodingGzip = "gzip"
+	EncodingDeflate = "deflate"
+
+	// Plage d'octets : "Get <filename> [<encodages>] range=<debut>-<fin>"
+	// (fin exclue) envoie seulement cette partie du fichier, sans compression
+	PrefixePlage = "range="
 
 )
\ No newline at end of file
@@ -0,0 +1,163 @@
+package sendrec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrChunkFormat est retournée quand l'en-tête d'un bloc est invalide.
+var ErrChunkFormat = errors.New("invalid chunk header")
+
+// ChunkedWriter envoie un flux de longueur inconnue sous forme de blocs.
+// Chaque bloc est "<taille>\n" suivi de <taille> octets. Le flux se termine
+// par un bloc vide "0\n", suivi des trailers éventuels ("<clé> <valeur>\n")
+// puis d'une ligne vide.
+type ChunkedWriter struct {
+	out      *bufio.Writer
+	trailers []string
+	closed   bool
+}
+
+// NewChunkedWriter crée un ChunkedWriter qui écrit sur out.
+func NewChunkedWriter(out *bufio.Writer) *ChunkedWriter {
+	return &ChunkedWriter{out: out}
+}
+
+// Write envoie p sous forme d'un bloc (sans flush).
+func (cw *ChunkedWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("chunked writer closed")
+	}
+	// Un bloc vide signifierait la fin du flux
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.out, "%d\n", len(p)); err != nil {
+		return 0, err
+	}
+	return cw.out.Write(p)
+}
+
+// Flush force l'envoi des blocs en attente (utile pour un flux continu).
+func (cw *ChunkedWriter) Flush() error {
+	return cw.out.Flush()
+}
+
+// Trailer ajoute un trailer envoyé après le dernier bloc.
+func (cw *ChunkedWriter) Trailer(key, value string) {
+	cw.trailers = append(cw.trailers, key+" "+value)
+}
+
+// Close envoie le bloc de fin et les trailers, puis flush.
+// Le writer sous-jacent n'est pas fermé.
+func (cw *ChunkedWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	if _, err := cw.out.WriteString("0\n"); err != nil {
+		return err
+	}
+	for _, t := range cw.trailers {
+		if _, err := cw.out.WriteString(t + "\n"); err != nil {
+			return err
+		}
+	}
+	if _, err := cw.out.WriteString("\n"); err != nil {
+		return err
+	}
+	return cw.out.Flush()
+}
+
+// ChunkedReader lit un flux écrit par un ChunkedWriter.
+// Read retourne io.EOF une fois le bloc de fin et les trailers lus.
+type ChunkedReader struct {
+	in        *bufio.Reader
+	remaining int64
+	done      bool
+	trailers  map[string]string
+}
+
+// NewChunkedReader crée un ChunkedReader qui lit depuis in.
+func NewChunkedReader(in *bufio.Reader) *ChunkedReader {
+	return &ChunkedReader{in: in, trailers: make(map[string]string)}
+}
+
+// Read lit les données des blocs successifs.
+func (cr *ChunkedReader) Read(p []byte) (int, error) {
+	if cr.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// Lire l'en-tête du bloc suivant
+	if cr.remaining == 0 {
+		size, err := cr.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			if err := cr.readTrailers(); err != nil {
+				return 0, err
+			}
+			cr.done = true
+			return 0, io.EOF
+		}
+		cr.remaining = size
+	}
+
+	if int64(len(p)) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.in.Read(p)
+	cr.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Trailers retourne les trailers reçus (complets une fois io.EOF atteint).
+func (cr *ChunkedReader) Trailers() map[string]string {
+	return cr.trailers
+}
+
+func (cr *ChunkedReader) readHeader() (int64, error) {
+	line, err := cr.in.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrChunkFormat, strings.TrimSpace(line))
+	}
+	return size, nil
+}
+
+func (cr *ChunkedReader) readTrailers() error {
+	for {
+		line, err := cr.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return nil
+		}
+		key, value, _ := strings.Cut(line, " ")
+		cr.trailers[key] = value
+	}
+}
@@ -0,0 +1,49 @@
+package sendrec
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/proto"
+)
+
+// NewCompressor retourne un writer qui compresse avec l'encodage demandé
+// avant d'écrire sur w. Close doit être appelé pour vider le compresseur
+// (w n'est pas fermé).
+func NewCompressor(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case proto.EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case proto.EncodingDeflate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// NewDecompressor retourne un reader qui décompresse le contenu lu depuis r.
+func NewDecompressor(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case proto.EncodingGzip:
+		return gzip.NewReader(r)
+	case proto.EncodingDeflate:
+		return flate.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+}
+
+// CountingWriter compte les octets écrits sur W.
+type CountingWriter struct {
+	W io.Writer
+	N int64
+}
+
+// Write écrit p sur W et met à jour le compteur.
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.W.Write(p)
+	cw.N += int64(n)
+	return n, err
+}
@@ -2,12 +2,24 @@ package sendrec
 
 import (
 	"bufio"
+	"context"
 	"log/slog"
+
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logctx"
+	"gitlab.univ-nantes.fr/iutna.info2.r305/proj/internal/pkg/logging"
 )
 
+// Sous-systeme des traces de messages, reglable independamment (voir logging)
+const sousSysteme = "protocol"
+
+func logger(ctx context.Context) *slog.Logger {
+	return logctx.From(ctx).With(logging.CleSousSysteme, sousSysteme)
+}
+
 // SendMessage écrit un message (qui doit contenir un '\n') puis flush.
 // Le serveur/client doivent s'assurer d'inclure le '\n' dans message.
-func SendMessage(out *bufio.Writer, message string) error {
+// Le log de debug utilise le logger porte par ctx (voir logctx).
+func SendMessage(ctx context.Context, out *bufio.Writer, message string) error {
 
 	_, err := out.WriteString(message)
 	if err != nil {
@@ -19,9 +31,9 @@ func SendMessage(out *bufio.Writer, message string) error {
 
 	// Pour le log : si message finit par '\n', on l'enlève
 	if len(message) > 0 && message[len(message)-1] == '\n' {
-		slog.Debug("Sending message", "msg", message[:len(message)-1])
+		logger(ctx).Debug("Sending message", "message", message[:len(message)-1])
 	} else {
-		slog.Debug("Sending message", "msg", message)
+		logger(ctx).Debug("Sending message", "message", message)
 	}
 
 	return nil
@@ -29,7 +41,7 @@ func SendMessage(out *bufio.Writer, message string) error {
 
 // ReceiveMessage lit une ligne terminée par '\n'
 // et retourne la ligne SANS le '\n'
-func ReceiveMessage(in *bufio.Reader) (string, error) {
+func ReceiveMessage(ctx context.Context, in *bufio.Reader) (string, error) {
 
 	line, err := in.ReadString('\n')
 	if err != nil {
@@ -39,6 +51,6 @@ func ReceiveMessage(in *bufio.Reader) (string, error) {
 	// retirer le '\n'
 	line = line[:len(line)-1]
 
-	slog.Debug("Received message", "msg", line)
+	logger(ctx).Debug("Received message", "message", line)
 	return line, nil
 }